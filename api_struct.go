@@ -9,9 +9,72 @@ type ApiVersion struct {
 }
 
 type ApiProcess struct {
-	Name   string
-	Pid    int
-	Status int
+	Name        string
+	Pid         int
+	Status      int
+	StartedAt   time.Time
+	Port        int
+	BufferLines int
+	BufferBytes int
+	ExitReason  string             `json:",omitempty"`
+	Resources   *ApiResourceSample `json:",omitempty"`
+	Schedule    string             `json:",omitempty"`
+	NextRun     *time.Time         `json:",omitempty"`
+	Lazy        string             `json:",omitempty"`
+}
+
+// Resources used by a process and its children. RSS is in bytes, CPU in
+// percent of one core.
+type ApiResourceSample struct {
+	Time     time.Time
+	CPU      float64
+	RSS      uint64
+	Threads  int
+	FDs      int
+	Children []int
+}
+
+// Where a service or a process can be reached over HTTP
+type ApiURL struct {
+	URL string
+}
+
+// The DNS server of rigd and the hosts it answers for
+type ApiDNS struct {
+	Addr    string
+	Domain  string
+	Forward string
+	Hosts   []string
+}
+
+// How to run a one-off command in the context of a service or a process
+// Env is added to the environment of whoever runs the command
+type ApiCommand struct {
+	Dir  string
+	Args []string
+	Env  []string
+}
+
+type ApiTask struct {
+	Name    string
+	Command string
+	Runs    []ApiTaskRun
+}
+
+// A run of a task or a scheduled process. ExitCode is -1 if it couldn't be
+// started.
+type ApiTaskRun struct {
+	Task      string
+	StartedAt time.Time
+	Duration  time.Duration
+	ExitCode  int
+	Error     string `json:",omitempty"`
+}
+
+// Running a task streams its output, then its result
+type ApiTaskEvent struct {
+	Output *ProcessOutputMessage `json:",omitempty"`
+	Result *ApiTaskRun           `json:",omitempty"`
 }
 
 type Descriptor struct {
@@ -26,4 +89,19 @@ type ProcessOutputMessage struct {
 	Service string
 	Process string
 	Time    time.Time
+	Stream  string `json:",omitempty"`
+
+	// Fields parsed from structured (JSON) output. Time is when rig received
+	// the line, LogTime when the process says it logged it.
+	Level   string            `json:",omitempty"`
+	Msg     string            `json:",omitempty"`
+	Error   string            `json:",omitempty"`
+	LogTime *time.Time        `json:",omitempty"`
+	Fields  map[string]string `json:",omitempty"`
+}
+
+type ApiSearchResult struct {
+	Message    ProcessOutputMessage
+	Match      bool
+	Highlights [][]int
 }
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/gocardless/rig"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const defaultDetachKeys = "ctrl-p,ctrl-q"
+
+func (c *Cli) CmdAttach(args ...string) error {
+	cmd := c.Subcmd("attach", "DESCRIPTOR", "Show the output of a process and send it what you type")
+	num := cmd.Int("n", 10, "Number of past lines to show")
+	detachKeys := cmd.String("detach-keys", defaultDetachKeys, "Keys to type to detach from a process with a terminal")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+
+	keys, err := parseDetachKeys(*detachKeys)
+	if err != nil {
+		return err
+	}
+
+	d, err := c.resolveDescriptor(cmd.Arg(0))
+	if err != nil {
+		return err
+	}
+	if d.Process == "" {
+		return fmt.Errorf("Error: %s isn't a process", cmd.Arg(0))
+	}
+
+	v := url.Values{}
+	v.Set("num", strconv.Itoa(*num))
+	path := fmt.Sprintf("/%s/%s/%s/attach?%s", d.Stack, d.Service, d.Process, v.Encode())
+	conn, output, tty, err := c.hijack("POST", path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Without a terminal the process gets whole lines, which the local
+	// terminal edits, and Ctrl-D detaches.
+	detach := ""
+	if tty {
+		state, err := makeRawInput()
+		if err == nil {
+			defer restoreTerminal(state)
+			detach = *detachKeys
+		}
+	}
+	if detach != "" {
+		fmt.Fprintf(os.Stderr, "Attached to %s, detach with %s\n", d.Process, detach)
+	} else {
+		fmt.Fprintf(os.Stderr, "Attached to %s, detach with ctrl-d\n", d.Process)
+	}
+
+	exited := make(chan bool)
+	go func() {
+		io.Copy(os.Stdout, output)
+		close(exited)
+	}()
+
+	detached := make(chan bool)
+	go func() {
+		forwardInput(conn, os.Stdin, keys, detach != "")
+		close(detached)
+	}()
+
+	select {
+	case <-exited:
+		fmt.Fprintf(os.Stderr, "%s exited\n", d.Process)
+	case <-detached:
+		fmt.Fprintf(os.Stderr, "\nDetached from %s\n", d.Process)
+	}
+	return nil
+}
+
+// forwardInput copies input to conn until it ends or, when detachable, the
+// detach keys are typed.
+func forwardInput(conn io.Writer, input io.Reader, keys []byte, detachable bool) {
+	scanner := &detachScanner{keys: keys}
+	buf := make([]byte, 1024)
+	for {
+		n, err := input.Read(buf)
+		if n > 0 {
+			b, detach := buf[:n], false
+			if detachable {
+				b, detach = scanner.scan(b)
+			}
+			if _, err := conn.Write(b); err != nil || detach {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// A detachScanner finds the detach keys in input. Keys which might be the
+// start of the sequence are held back until the next ones tell.
+type detachScanner struct {
+	keys    []byte
+	matched int
+}
+
+// scan returns the input to forward, and whether the detach keys were typed
+func (s *detachScanner) scan(input []byte) ([]byte, bool) {
+	var out []byte
+	for _, b := range input {
+		if b == s.keys[s.matched] {
+			s.matched++
+			if s.matched == len(s.keys) {
+				return out, true
+			}
+			continue
+		}
+		out = append(out, s.keys[:s.matched]...)
+		s.matched = 0
+		if b == s.keys[0] {
+			s.matched = 1
+			continue
+		}
+		out = append(out, b)
+	}
+	return out, false
+}
+
+// parseDetachKeys parses a comma separated list of keys, each a character
+// or ctrl-<character> such as ctrl-p.
+func parseDetachKeys(str string) ([]byte, error) {
+	var keys []byte
+	for _, key := range strings.Split(str, ",") {
+		switch {
+		case len(key) == 1:
+			keys = append(keys, key[0])
+		case strings.HasPrefix(key, "ctrl-") && len(key) == 6:
+			c := key[5]
+			if c >= 'a' && c <= 'z' {
+				c -= 'a' - 'A'
+			}
+			if c < '@' || c > '_' {
+				return nil, fmt.Errorf("Error: invalid detach key '%s'", key)
+			}
+			keys = append(keys, c-'@')
+		default:
+			return nil, fmt.Errorf("Error: invalid detach key '%s'", key)
+		}
+	}
+	return keys, nil
+}
+
+// hijack makes a request which rigd answers by taking over the connection,
+// and returns the connection, its output and whether the process attached to
+// has a terminal.
+func (c *Cli) hijack(method, path string) (net.Conn, io.Reader, bool, error) {
+	conn, err := net.Dial("tcp", c.addr)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	req, err := http.NewRequest(method, fmt.Sprintf("%s://%s%s", c.proto, c.addr, path), nil)
+	if err != nil {
+		conn.Close()
+		return nil, nil, false, err
+	}
+	req.Header.Set("User-Agent", "Rig-Client/"+rig.Version)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "rig-attach")
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, false, err
+	}
+
+	output := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(output, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, false, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := ioutil.ReadAll(resp.Body)
+		conn.Close()
+		if len(body) == 0 {
+			return nil, nil, false, fmt.Errorf("Error: %s", http.StatusText(resp.StatusCode))
+		}
+		return nil, nil, false, fmt.Errorf("Error: %s", strings.TrimSpace(string(body)))
+	}
+
+	return conn, output, resp.Header.Get("Rig-Tty") == "true", nil
+}
@@ -12,9 +12,28 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
+// A flag which can be given several times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 type Cli struct {
 	client *http.Client
 	proto  string
@@ -32,14 +51,24 @@ func NewCli(proto, addr string) *Cli {
 
 func (c *Cli) ParseCommand(args ...string) error {
 	cmds := map[string]func(args ...string) error{
+		"attach":  c.CmdAttach,
+		"dns":     c.CmdDNS,
 		"help":    c.CmdHelp,
 		"list":    c.CmdList,
+		"open":    c.CmdOpen,
 		"ps":      c.CmdPs,
 		"reload":  c.CmdReload,
 		"restart": c.CmdRestart,
+		"run":     c.CmdRun,
+		"runs":    c.CmdRuns,
+		"search":  c.CmdSearch,
+		"signal":  c.CmdSignal,
 		"start":   c.CmdStart,
 		"stop":    c.CmdStop,
 		"tail":    c.CmdTail,
+		"task":    c.CmdTask,
+		"top":     c.CmdTop,
+		"ui":      c.CmdTop,
 		"version": c.CmdVersion,
 	}
 
@@ -64,14 +93,23 @@ func (c *Cli) Subcmd(name, signature, description string) *flag.FlagSet {
 func (c *Cli) CmdHelp(args ...string) error {
 	help := "Usage: rig [OPTIONS] COMMAND DESCRIPTOR \n\nCommands:\n"
 	for _, cmd := range [][]string{
+		{"attach", "Attach to the output and input of a process"},
+		{"dns", "Check that the hosts of the stacks resolve (dns check)"},
 		{"help", "Show rig help"},
 		{"list", "List stacks, services and processes"},
+		{"open", "Open a service or a process in the browser"},
 		{"ps", "Show running processes"},
 		{"restart", "Restart a stack, a service or a process"},
 		{"reload", "Reload configuration"},
+		{"run", "Run a command in the context of a service or a process"},
+		{"runs", "Show the last runs of a scheduled process"},
+		{"search", "Search the logs of a stack, a service or a process"},
+		{"signal", "Send a signal to a stack, a service or a process"},
 		{"start", "Start a stack, a service or a process"},
 		{"stop", "Stop a stack, a service or a process"},
 		{"tail", "Tail logs of a stack, a service or a process"},
+		{"task", "Run a task of a service, or list them"},
+		{"top", "Show stacks, services, processes and logs in a dashboard (alias: ui)"},
 		{"version", "Show the rig version"},
 	} {
 		help += fmt.Sprintf("    %-10.10s%s\n", cmd[0], cmd[1])
@@ -103,7 +141,7 @@ func (c *Cli) CmdList(args ...string) error {
 		return err
 	}
 
-	fmt.Println("Stack list:\n")
+	fmt.Println("Stack list:")
 	for stackName, s := range stacks {
 		fmt.Printf("\x1b[1m- %s :\x1b[0m\n", stackName)
 		for serviceName, svc := range s {
@@ -117,8 +155,48 @@ func (c *Cli) CmdList(args ...string) error {
 	return nil
 }
 
+func (c *Cli) CmdOpen(args ...string) error {
+	cmd := c.Subcmd("open", "DESCRIPTOR", "Open a service (its web process) or a process in the browser")
+	printOnly := cmd.Bool("print", false, "Only print the URL")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+
+	d, err := c.resolveDescriptor(cmd.Arg(0))
+	if err != nil {
+		return err
+	}
+	if d.Service == "" {
+		return fmt.Errorf("Error: %s isn't a service or a process", cmd.Arg(0))
+	}
+	path := fmt.Sprintf("/%s/%s", d.Stack, d.Service)
+	if d.Process != "" {
+		path += "/" + d.Process
+	}
+
+	body, _, err := c.call("GET", path+"/url", nil)
+	if err != nil {
+		return err
+	}
+	var u rig.ApiURL
+	if err := json.Unmarshal(body, &u); err != nil {
+		return fmt.Errorf("Error unmarshal: body: %s, err: %s\n", body, err)
+	}
+
+	fmt.Println(u.URL)
+	if *printOnly {
+		return nil
+	}
+	opener := "xdg-open"
+	if runtime.GOOS == "darwin" {
+		opener = "open"
+	}
+	return exec.Command(opener, u.URL).Run()
+}
+
 func (c *Cli) CmdPs(args ...string) error {
 	cmd := c.Subcmd("ps", "", "Show running processes")
+	resources := cmd.Bool("resources", false, "Show the CPU, memory, threads and file descriptors used by each process and its children")
 	if err := cmd.Parse(args); err != nil {
 		return nil
 	}
@@ -141,18 +219,39 @@ func (c *Cli) CmdPs(args ...string) error {
 	}
 
 	t := termtable.NewTable(nil, &termtable.TableOptions{Padding: 2})
-	t.SetHeader([]string{"PID", "Name", "Status"})
+	header := []string{"PID", "Name", "Status"}
+	if *resources {
+		header = append(header, "CPU", "RSS", "Threads", "FDs", "Children")
+	}
+	t.SetHeader(header)
 	for stackName, s := range stacks {
 		for serviceName, svc := range s {
 			for _, process := range svc {
 				var status string
-				if process.Status == 1 {
+				if process.Lazy != "" {
+					status = strings.Title(process.Lazy)
+				} else if process.Status == 1 {
 					status = "Running"
+				} else if process.Status == 2 {
+					status = "Starting"
+				} else if process.NextRun != nil {
+					status = "Scheduled (next run " + process.NextRun.Format("Jan 2 15:04") + ")"
 				} else {
 					status = "Stopped"
 				}
+				if process.Status == 0 && process.ExitReason != "" {
+					status += " (" + process.ExitReason + ")"
+				}
 				d := fmt.Sprintf("%s:%s:%s", stackName, serviceName, process.Name)
-				t.AddRow([]string{strconv.Itoa(process.Pid), d, status})
+				pid := "-"
+				if process.Pid != 0 {
+					pid = strconv.Itoa(process.Pid)
+				}
+				row := []string{pid, d, status}
+				if *resources {
+					row = append(row, formatResources(process.Resources)...)
+				}
+				t.AddRow(row)
 			}
 		}
 	}
@@ -161,8 +260,164 @@ func (c *Cli) CmdPs(args ...string) error {
 	return nil
 }
 
+func formatResources(r *rig.ApiResourceSample) []string {
+	if r == nil {
+		return []string{"-", "-", "-", "-", "-"}
+	}
+	children := make([]string, len(r.Children))
+	for i, pid := range r.Children {
+		children[i] = strconv.Itoa(pid)
+	}
+	return []string{
+		fmt.Sprintf("%.1f%%", r.CPU),
+		formatBytes(r.RSS),
+		strconv.Itoa(r.Threads),
+		strconv.Itoa(r.FDs),
+		strings.Join(children, ","),
+	}
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func (c *Cli) CmdRun(args ...string) error {
+	cmd := c.Subcmd("run", "[DESCRIPTOR] -- COMMAND | DESCRIPTOR COMMAND", "Run a command in the directory and environment of a service or a process")
+
+	// Only what comes before -- can be flags
+	dash := len(args)
+	for i, arg := range args {
+		if arg == "--" {
+			dash = i
+			break
+		}
+	}
+	if err := cmd.Parse(args[:dash]); err != nil {
+		return nil
+	}
+	descriptor, command, ok := splitRunArgs(append(append([]string{}, cmd.Args()...), args[dash:]...))
+	if !ok {
+		cmd.Usage()
+		return nil
+	}
+
+	d, err := c.resolveDescriptor(descriptor)
+	if err != nil {
+		return err
+	}
+	if d.Service == "" {
+		return fmt.Errorf("Commands can only be run in a service or a process")
+	}
+
+	path := fmt.Sprintf("/%s/%s", d.Stack, d.Service)
+	if d.Process != "" {
+		path += "/" + d.Process
+	}
+	v := url.Values{}
+	v.Set("command", shellJoin(command))
+
+	body, _, err := c.call("GET", path+"/command?"+v.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	var run rig.ApiCommand
+	if err := json.Unmarshal(body, &run); err != nil {
+		return fmt.Errorf("Error unmarshal: body: %s, err: %s", body, err)
+	}
+
+	// Replace rig with the command, so it gets the terminal and its exit
+	// status is rig's
+	if err := os.Chdir(run.Dir); err != nil {
+		return err
+	}
+	return syscall.Exec(run.Args[0], run.Args, mergeEnv(os.Environ(), run.Env))
+}
+
+// splitRunArgs splits the arguments of rig run into the descriptor and the
+// command. Everything after -- is the command, and the descriptor before it
+// is optional. Without --, the first argument is the descriptor.
+func splitRunArgs(args []string) (descriptor string, command []string, ok bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			if i > 1 {
+				return "", nil, false
+			}
+			if i == 1 {
+				descriptor = args[0]
+			}
+			return descriptor, args[i+1:], len(args) > i+1
+		}
+	}
+	if len(args) < 2 {
+		return "", nil, false
+	}
+	return args[0], args[1:], true
+}
+
+// shellJoin turns the arguments of rig run into a shell command. A single
+// argument is taken as the command itself, so that it can use the shell;
+// several are quoted so that each stays one argument.
+func shellJoin(args []string) string {
+	if len(args) == 1 {
+		return args[0]
+	}
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+func shellQuote(arg string) string {
+	if shellSafe.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
+
+// mergeEnv sets the variables of extra in env, replacing any it has already
+func mergeEnv(env, extra []string) []string {
+	merged := []string{}
+	for _, v := range env {
+		name := strings.SplitN(v, "=", 2)[0]
+		replaced := false
+		for _, e := range extra {
+			if strings.HasPrefix(e, name+"=") {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, v)
+		}
+	}
+	return append(merged, extra...)
+}
+
 func (c *Cli) CmdRestart(args ...string) error {
-	return nil
+	cmd := c.Subcmd("restart", "[DESCRIPTOR...]", "Restart stacks, services or processes")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+
+	paths, err := c.resolveTargets(cmd.Args())
+	if err != nil {
+		return err
+	}
+
+	return c.postEach(paths, "/restart")
 }
 
 func (c *Cli) CmdReload(args ...string) error {
@@ -179,73 +434,402 @@ func (c *Cli) CmdReload(args ...string) error {
 	return nil
 }
 
-func (c *Cli) CmdStart(args ...string) error {
-	cmd := c.Subcmd("start", "DESCRIPTOR", "Start a stack, a service or a process")
-	tail := cmd.Bool("tail", false, "Tail the logs after starting")
+func (c *Cli) CmdSearch(args ...string) error {
+	cmd := c.Subcmd("search", "QUERY [DESCRIPTOR]", "Search the logs of a stack, a service or a process (everything by default)")
+	since := cmd.String("since", "", "Only search lines more recent than this, e.g. 30m, 6h or 1d")
+	context := cmd.Int("C", 0, "Number of lines of context to show around matches")
+	useRegexp := cmd.Bool("regexp", false, "Treat the query as a regexp rather than a case insensitive string")
 	if err := cmd.Parse(args); err != nil {
 		return nil
 	}
 
-	path, err := c.resolve(cmd.Arg(0))
+	if cmd.NArg() < 1 || cmd.NArg() > 2 {
+		cmd.Usage()
+		return nil
+	}
+
+	v := url.Values{}
+	v.Set("query", cmd.Arg(0))
+	v.Set("since", *since)
+	v.Set("context", strconv.Itoa(*context))
+	if *useRegexp {
+		v.Set("regexp", "1")
+	}
+
+	if cmd.NArg() == 2 {
+		d, err := c.resolveDescriptor(cmd.Arg(1))
+		if err != nil {
+			return err
+		}
+		v.Set("stack", d.Stack)
+		v.Set("service", d.Service)
+		v.Set("process", d.Process)
+	}
+
+	body, _, err := c.call("GET", "/search?"+v.Encode(), nil)
 	if err != nil {
 		return err
 	}
-	startPath := path + "/start"
 
-	_, _, err = c.call("POST", startPath, nil)
+	var results []*rig.ApiSearchResult
+	err = json.Unmarshal(body, &results)
 	if err != nil {
+		fmt.Printf("Error unmarshal: body: %s, err: %s\n", body, err)
 		return err
 	}
 
-	if *tail {
-		tailPath := path + "/tail"
+	logger := NewProcessLogger()
+	for _, r := range results {
+		logger.PrintHighlighted(r.Message, r.Highlights)
+	}
+
+	return nil
+}
+
+func (c *Cli) CmdSignal(args ...string) error {
+	cmd := c.Subcmd("signal", "[DESCRIPTOR...] SIGNAL", "Send a signal, such as HUP or USR1, to stacks, services or processes")
+	group := cmd.Bool("group", false, "Send it to the whole process group of each process, including its children")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if cmd.NArg() == 0 {
+		cmd.Usage()
+		return nil
+	}
+
+	descriptors, signal := cmd.Args()[:cmd.NArg()-1], cmd.Arg(cmd.NArg()-1)
 
-		err = c.stream("POST", tailPath, nil)
+	paths, err := c.resolveTargets(descriptors)
+	if err != nil {
+		return err
+	}
+	v := url.Values{}
+	v.Set("signal", signal)
+	if *group {
+		v.Set("group", "true")
+	}
+
+	return c.forEach(paths, func(path string) error {
+		body, _, err := c.call("POST", path+"/signal?"+v.Encode(), nil)
 		if err != nil {
 			return err
 		}
+
+		var signalled []string
+		if err := json.Unmarshal(body, &signalled); err != nil {
+			return fmt.Errorf("Error unmarshal: body: %s, err: %s\n", body, err)
+		}
+		for _, name := range signalled {
+			fmt.Printf("Sent %s to %s\n", signal, name)
+		}
+		return nil
+	})
+}
+
+func (c *Cli) CmdStart(args ...string) error {
+	cmd := c.Subcmd("start", "[DESCRIPTOR...]", "Start stacks, services or processes")
+	tail := cmd.Bool("tail", false, "Tail the logs after starting")
+	killConflicts := cmd.Bool("kill-conflicts", false, "Kill processes listening on the ports of the processes to start")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+
+	paths, err := c.resolveTargets(cmd.Args())
+	if err != nil {
+		return err
+	}
+	action := "/start"
+	if *killConflicts {
+		action += "?kill_conflicts=true"
+	}
+
+	if err := c.postEach(paths, action); err != nil {
+		return err
+	}
+
+	if *tail {
+		return c.tail(paths, url.Values{}, 20)
 	}
 
 	return nil
 }
 
 func (c *Cli) CmdStop(args ...string) error {
-	cmd := c.Subcmd("stop", "DESCRIPTOR", "Stop a stack, a service or a process")
+	cmd := c.Subcmd("stop", "[DESCRIPTOR...]", "Stop stacks, services or processes")
 	if err := cmd.Parse(args); err != nil {
 		return nil
 	}
 
-	path, err := c.resolve(cmd.Arg(0))
+	paths, err := c.resolveTargets(cmd.Args())
 	if err != nil {
 		return err
 	}
-	path += "/stop"
 
-	_, _, err = c.call("POST", path, nil)
+	return c.postEach(paths, "/stop")
+}
+
+func (c *Cli) CmdTail(args ...string) error {
+	cmd := c.Subcmd("tail", "[DESCRIPTOR...]", "Tail logs of stacks, services or processes, merged into one stream")
+	num := cmd.Int("n", 20, "Number of past lines to show")
+	var include, exclude, contains stringList
+	cmd.Var(&include, "grep", "Only show lines matching this regexp (can be repeated)")
+	cmd.Var(&exclude, "exclude", "Hide lines matching this regexp (can be repeated)")
+	cmd.Var(&contains, "match", "Only show lines containing this string, ignoring case (can be repeated)")
+	level := cmd.String("level", "", "Only show structured lines of this level or above (debug, info, warn, error...)")
+	stripANSI := cmd.Bool("strip-ansi", false, "Remove colours and other terminal escape sequences from lines")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+
+	paths, err := c.resolveTargets(cmd.Args())
 	if err != nil {
 		return err
 	}
 
+	v := url.Values{}
+	v["include"] = include
+	v["exclude"] = exclude
+	v["contains"] = contains
+	if *level != "" {
+		v.Set("level", *level)
+	}
+	if *stripANSI {
+		v.Set("strip_ansi", "true")
+	}
+
+	return c.tail(paths, v, *num)
+}
+
+// tail streams the output of every path, filtered with v, as one stream
+// starting with their last num lines in order.
+func (c *Cli) tail(paths []string, v url.Values, num int) error {
+	if len(paths) == 1 {
+		v.Set("num", strconv.Itoa(num))
+		return c.stream("POST", paths[0]+"/tail?"+v.Encode(), nil)
+	}
+
+	// Every stream is subscribed to before reading the history, so that
+	// nothing logged in between is lost. What's logged meanwhile comes in
+	// both, and is only shown once.
+	v.Set("num", "0")
+	var bodies []io.ReadCloser
+	defer func() {
+		for _, body := range bodies {
+			body.Close()
+		}
+	}()
+	for _, path := range paths {
+		body, err := c.openStream("POST", path+"/tail?"+v.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		bodies = append(bodies, body)
+	}
+	messages := make(chan rig.ProcessOutputMessage)
+	errs := make(chan error, len(paths))
+	for _, body := range bodies {
+		go func(body io.Reader) {
+			errs <- decodeStream(body, func(dec *json.Decoder) error {
+				m := rig.ProcessOutputMessage{}
+				if err := dec.Decode(&m); err != nil {
+					return err
+				}
+				messages <- m
+				return nil
+			})
+		}(body)
+	}
+
+	logger := NewProcessLogger()
+	shown := map[messageKey]bool{}
+	if num > 0 {
+		v.Set("num", strconv.Itoa(num))
+		var history []rig.ProcessOutputMessage
+		for _, path := range paths {
+			body, _, err := c.call("GET", path+"/history?"+v.Encode(), nil)
+			if err != nil {
+				return err
+			}
+			var messages []rig.ProcessOutputMessage
+			if err := json.Unmarshal(body, &messages); err != nil {
+				return fmt.Errorf("Error unmarshal: body: %s, err: %s\n", body, err)
+			}
+			history = append(history, messages...)
+		}
+		sort.SliceStable(history, func(i, j int) bool {
+			return history[i].Time.Before(history[j].Time)
+		})
+		if len(history) > num {
+			history = history[len(history)-num:]
+		}
+		for _, m := range history {
+			logger.Println(m)
+			shown[keyOf(m)] = true
+		}
+	}
+
+	for {
+		select {
+		case m := <-messages:
+			if k := keyOf(m); shown[k] {
+				delete(shown, k)
+				continue
+			}
+			logger.Println(m)
+		case err := <-errs:
+			return err
+		}
+	}
+}
+
+// A messageKey identifies a line of a process by when it was logged
+type messageKey struct {
+	stack, service, process, stream, content string
+	time                                     int64
+}
+
+func keyOf(m rig.ProcessOutputMessage) messageKey {
+	return messageKey{m.Stack, m.Service, m.Process, m.Stream, m.Content, m.Time.UnixNano()}
+}
+
+func (c *Cli) CmdTask(args ...string) error {
+	cmd := c.Subcmd("task", "[SERVICE] TASK", "Run a task of a service, exiting with its exit status")
+	list := cmd.Bool("list", false, "List the tasks of the service and their last run instead")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if (*list && cmd.NArg() > 1) || (!*list && (cmd.NArg() == 0 || cmd.NArg() > 2)) {
+		cmd.Usage()
+		return nil
+	}
+
+	// The task is an argument of its own, as task names can contain colons
+	descriptor, task := "", ""
+	switch {
+	case *list:
+		descriptor = cmd.Arg(0)
+	case cmd.NArg() == 1:
+		task = cmd.Arg(0)
+	default:
+		descriptor, task = cmd.Arg(0), cmd.Arg(1)
+	}
+	d, err := c.resolveDescriptor(descriptor)
+	if err != nil {
+		return err
+	}
+	if d.Service == "" || d.Process != "" {
+		return fmt.Errorf("Error: %s isn't a service", descriptor)
+	}
+	path := fmt.Sprintf("/%s/%s/tasks", d.Stack, d.Service)
+
+	if *list {
+		return c.listTasks(path)
+	}
+
+	logger := NewProcessLogger()
+	var result *rig.ApiTaskRun
+	err = c.streamJSON("POST", path+"/"+url.PathEscape(task)+"/run", nil, func(dec *json.Decoder) error {
+		var event rig.ApiTaskEvent
+		if err := dec.Decode(&event); err != nil {
+			return err
+		}
+		if event.Output != nil {
+			logger.Println(*event.Output)
+		}
+		if event.Result != nil {
+			result = event.Result
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		return fmt.Errorf("Error: the task's result is missing")
+	}
+
+	if result.ExitCode < 0 {
+		return fmt.Errorf("Error: %s", result.Error)
+	}
+	fmt.Printf("Task %s exited with code %d after %v\n", task, result.ExitCode, result.Duration-result.Duration%time.Millisecond)
+	if result.ExitCode != 0 {
+		os.Exit(result.ExitCode)
+	}
 	return nil
 }
 
-func (c *Cli) CmdTail(args ...string) error {
-	cmd := c.Subcmd("stop", "DESCRIPTOR", "Stop a stack, a service or a process")
+func (c *Cli) CmdRuns(args ...string) error {
+	cmd := c.Subcmd("runs", "PROCESS", "Show the last runs of a scheduled process, with their exit codes")
 	if err := cmd.Parse(args); err != nil {
 		return nil
 	}
+	if cmd.NArg() != 1 {
+		cmd.Usage()
+		return nil
+	}
+
+	d, err := c.resolveDescriptor(cmd.Arg(0))
+	if err != nil {
+		return err
+	}
+	if d.Process == "" {
+		return fmt.Errorf("Only processes have runs")
+	}
 
-	path, err := c.resolve(cmd.Arg(0))
+	body, _, err := c.call("GET", fmt.Sprintf("/%s/%s/%s/runs", d.Stack, d.Service, d.Process), nil)
 	if err != nil {
 		return err
 	}
-	path += "/tail"
 
-	err = c.stream("POST", path, nil)
+	var runs []rig.ApiTaskRun
+	if err := json.Unmarshal(body, &runs); err != nil {
+		return fmt.Errorf("Error unmarshal: body: %s, err: %s", body, err)
+	}
+	if len(runs) == 0 {
+		fmt.Printf("%s:%s:%s hasn't run on a schedule yet\n", d.Stack, d.Service, d.Process)
+		return nil
+	}
+
+	t := termtable.NewTable(nil, &termtable.TableOptions{Padding: 2})
+	t.SetHeader([]string{"Started", "Duration", "Exit code", "Error"})
+	for _, run := range runs {
+		t.AddRow([]string{
+			run.StartedAt.Format("2006-01-02 15:04:05"),
+			(run.Duration - run.Duration%time.Millisecond).String(),
+			strconv.Itoa(run.ExitCode),
+			run.Error,
+		})
+	}
+	t.Render()
+
+	return nil
+}
+
+func (c *Cli) listTasks(path string) error {
+	body, _, err := c.call("GET", path, nil)
 	if err != nil {
 		return err
 	}
 
+	var tasks []*rig.ApiTask
+	if err := json.Unmarshal(body, &tasks); err != nil {
+		return fmt.Errorf("Error unmarshal: body: %s, err: %s", body, err)
+	}
+
+	t := termtable.NewTable(nil, &termtable.TableOptions{Padding: 2})
+	t.SetHeader([]string{"Name", "Command", "Last run", "Duration", "Exit code"})
+	for _, task := range tasks {
+		row := []string{task.Name, task.Command, "-", "-", "-"}
+		if len(task.Runs) > 0 {
+			run := task.Runs[len(task.Runs)-1]
+			row[2] = run.StartedAt.Format("2006-01-02 15:04:05")
+			row[3] = (run.Duration - run.Duration%time.Millisecond).String()
+			row[4] = strconv.Itoa(run.ExitCode)
+		}
+		t.AddRow(row)
+	}
+	t.Render()
+
 	return nil
 }
 
@@ -276,39 +860,137 @@ func (c *Cli) CmdVersion(args ...string) error {
 	return nil
 }
 
-func (c *Cli) resolve(descriptor string) (string, error) {
+func descriptorPath(d *rig.Descriptor) string {
+	path := fmt.Sprintf("/%s", d.Stack)
+
+	if d.Service != "" {
+		path += fmt.Sprintf("/%s", d.Service)
+	}
+
+	if d.Process != "" {
+		path += fmt.Sprintf("/%s", d.Process)
+	}
+
+	return path
+}
+
+// resolveTargets resolves every descriptor, which can be globs, to the paths
+// of what they refer to. Targets covered by another one, such as a process
+// of a service which is a target too, are left out.
+func (c *Cli) resolveTargets(descriptors []string) ([]string, error) {
+	if len(descriptors) == 0 {
+		// The service of the current directory
+		descriptors = []string{""}
+	}
+
+	var resolved []*rig.Descriptor
+	for _, descriptor := range descriptors {
+		ds, err := c.resolveDescriptors(descriptor)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, ds...)
+	}
+
+	var paths []string
+	for _, d := range dedupeDescriptors(resolved) {
+		paths = append(paths, descriptorPath(d))
+	}
+	return paths, nil
+}
+
+// dedupeDescriptors leaves out descriptors which another one covers,
+// keeping the first of equal ones
+func dedupeDescriptors(descriptors []*rig.Descriptor) []*rig.Descriptor {
+	var kept []*rig.Descriptor
+	for i, d := range descriptors {
+		covered := false
+		for j, other := range descriptors {
+			if i != j && covers(other, d) && (*other != *d || j < i) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			kept = append(kept, d)
+		}
+	}
+	return kept
+}
+
+// covers returns whether b is a, or a service or process under it
+func covers(a, b *rig.Descriptor) bool {
+	return a.Stack == b.Stack &&
+		(a.Service == "" || a.Service == b.Service && (a.Process == "" || a.Process == b.Process))
+}
+
+// resolveDescriptors resolves a descriptor which can be a glob, such as
+// "acme:*:worker", to everything it matches
+func (c *Cli) resolveDescriptors(descriptor string) ([]*rig.Descriptor, error) {
 	v := url.Values{}
 	v.Set("descriptor", descriptor)
+	v.Set("all", "true")
 	if pwd, err := os.Getwd(); err == nil {
 		v.Set("pwd", pwd)
 	}
 
-	resolveBody, _, err := c.call("GET", "/resolve?"+v.Encode(), nil)
+	body, _, err := c.call("GET", "/resolve?"+v.Encode(), nil)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	var d rig.Descriptor
-	err = json.Unmarshal(resolveBody, &d)
-	if err != nil {
-		return "", fmt.Errorf("Error unmarshal: body: %s, err: %s\n", resolveBody, err)
+	var descriptors []*rig.Descriptor
+	if err := json.Unmarshal(body, &descriptors); err != nil {
+		return nil, fmt.Errorf("Error unmarshal: body: %s, err: %s\n", body, err)
 	}
+	return descriptors, nil
+}
 
-	if d.Stack == "" {
-		return "", fmt.Errorf("Error : resolver couldn't find stack")
+// forEach calls f with every path, carrying on when it fails for some
+func (c *Cli) forEach(paths []string, f func(path string) error) error {
+	var errs []string
+	for _, path := range paths {
+		if err := f(path); err != nil {
+			errs = append(errs, strings.TrimSpace(err.Error()))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
 	}
+	return nil
+}
 
-	path := fmt.Sprintf("/%s", d.Stack)
+// postEach posts the action, such as "/stop", to every path
+func (c *Cli) postEach(paths []string, action string) error {
+	return c.forEach(paths, func(path string) error {
+		_, _, err := c.call("POST", path+action, nil)
+		return err
+	})
+}
 
-	if d.Service != "" {
-		path += fmt.Sprintf("/%s", d.Service)
+func (c *Cli) resolveDescriptor(descriptor string) (*rig.Descriptor, error) {
+	v := url.Values{}
+	v.Set("descriptor", descriptor)
+	if pwd, err := os.Getwd(); err == nil {
+		v.Set("pwd", pwd)
 	}
 
-	if d.Process != "" {
-		path += fmt.Sprintf("/%s", d.Process)
+	resolveBody, _, err := c.call("GET", "/resolve?"+v.Encode(), nil)
+	if err != nil {
+		return nil, err
 	}
 
-	return path, nil
+	var d rig.Descriptor
+	err = json.Unmarshal(resolveBody, &d)
+	if err != nil {
+		return nil, fmt.Errorf("Error unmarshal: body: %s, err: %s\n", resolveBody, err)
+	}
+
+	if d.Stack == "" {
+		return nil, fmt.Errorf("Error : resolver couldn't find stack")
+	}
+
+	return &d, nil
 }
 
 func (c *Cli) call(method, path string, data interface{}) ([]byte, int, error) {
@@ -349,11 +1031,42 @@ func (c *Cli) call(method, path string, data interface{}) ([]byte, int, error) {
 }
 
 func (c *Cli) stream(method, path string, data interface{}) error {
+	logger := NewProcessLogger()
+	return c.streamMessages(method, path, data, logger.Println)
+}
+
+// streamMessages calls f with every message of a streaming endpoint, until
+// the stream ends.
+func (c *Cli) streamMessages(method, path string, data interface{}, f func(rig.ProcessOutputMessage)) error {
+	return c.streamJSON(method, path, data, func(dec *json.Decoder) error {
+		m := rig.ProcessOutputMessage{}
+		if err := dec.Decode(&m); err != nil {
+			return err
+		}
+		f(m)
+		return nil
+	})
+}
+
+// streamJSON calls decode for every value of a streaming endpoint, until the
+// stream ends.
+func (c *Cli) streamJSON(method, path string, data interface{}, decode func(*json.Decoder) error) error {
+	body, err := c.openStream(method, path, data)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return decodeStream(body, decode)
+}
+
+// openStream requests a streaming endpoint, returning its body once the
+// response has started.
+func (c *Cli) openStream(method, path string, data interface{}) (io.ReadCloser, error) {
 	var reqBody io.Reader
 	if data != nil {
 		buf, err := json.Marshal(data)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		reqBody = bytes.NewBuffer(buf)
 	}
@@ -361,36 +1074,36 @@ func (c *Cli) stream(method, path string, data interface{}) error {
 	urlStr := fmt.Sprintf("%s://%s%s", c.proto, c.addr, path)
 	req, err := http.NewRequest(method, urlStr, reqBody)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	req.Header.Set("User-Agent", "Rig-Client/"+rig.Version)
 
 	resp, err := c.client.Do(req)
-	defer resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		defer resp.Body.Close()
 		body, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if len(body) == 0 {
-			return fmt.Errorf("Error :%s", http.StatusText(resp.StatusCode))
+			return nil, fmt.Errorf("Error :%s", http.StatusText(resp.StatusCode))
 		}
-		return fmt.Errorf("Error: %s", body)
+		return nil, fmt.Errorf("Error: %s", body)
 	}
+	return resp.Body, nil
+}
 
-	defer resp.Body.Close()
-
-	logger := NewProcessLogger()
-	dec := json.NewDecoder(resp.Body)
+func decodeStream(r io.Reader, decode func(*json.Decoder) error) error {
+	dec := json.NewDecoder(r)
 	for {
-		m := rig.ProcessOutputMessage{}
-		if err := dec.Decode(&m); err == io.EOF {
-			break
+		if err := decode(dec); err == io.EOF {
+			return nil
 		} else if err != nil {
 			return err
 		}
-		logger.Println(m)
 	}
-	return nil
 }
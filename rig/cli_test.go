@@ -0,0 +1,100 @@
+package main
+
+import (
+	"github.com/gocardless/rig"
+	"reflect"
+	"testing"
+)
+
+func Test_ShellJoin(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"echo $PORT"}, "echo $PORT"},
+		{[]string{"rails", "runner", "puts 1"}, "rails runner 'puts 1'"},
+		{[]string{"rake", "db:migrate", "VERSION=1"}, "rake db:migrate VERSION=1"},
+		{[]string{"echo", "it's", ""}, `echo 'it'\''s' ''`},
+	}
+
+	for _, test := range tests {
+		if joined := shellJoin(test.args); joined != test.expected {
+			t.Errorf("Expected %q to be joined into %s, got %s", test.args, test.expected, joined)
+		}
+	}
+}
+
+func Test_SplitRunArgs(t *testing.T) {
+	tests := []struct {
+		args       []string
+		descriptor string
+		command    []string
+	}{
+		{[]string{"acme:api", "--", "rails", "console"}, "acme:api", []string{"rails", "console"}},
+		{[]string{"--", "rake", "db:migrate"}, "", []string{"rake", "db:migrate"}},
+		{[]string{"acme:api", "rails", "console"}, "acme:api", []string{"rails", "console"}},
+		{[]string{"acme:api:web", "echo $PORT"}, "acme:api:web", []string{"echo $PORT"}},
+	}
+
+	for _, test := range tests {
+		descriptor, command, ok := splitRunArgs(test.args)
+		if !ok || descriptor != test.descriptor || !reflect.DeepEqual(command, test.command) {
+			t.Errorf("Expected %q to run %q in %s, got %q in %s (%v)", test.args, test.command, test.descriptor, command, descriptor, ok)
+		}
+	}
+
+	for _, args := range [][]string{{}, {"acme:api"}, {"acme:api", "--"}, {"--"}, {"acme:api", "rails", "--", "-e"}} {
+		if _, _, ok := splitRunArgs(args); ok {
+			t.Errorf("Expected %q to be rejected", args)
+		}
+	}
+}
+
+func Test_MergeEnv(t *testing.T) {
+	env := mergeEnv([]string{"HOME=/home/steve", "PORT=80", "PORTS=1"}, []string{"PORT=5000"})
+	expected := []string{"HOME=/home/steve", "PORTS=1", "PORT=5000"}
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("Expected %v, got %v", expected, env)
+	}
+}
+
+func Test_Covers(t *testing.T) {
+	tests := []struct {
+		a, b     rig.Descriptor
+		expected bool
+	}{
+		{rig.Descriptor{Stack: "acme"}, rig.Descriptor{Stack: "acme", Service: "api", Process: "web"}, true},
+		{rig.Descriptor{Stack: "acme", Service: "api"}, rig.Descriptor{Stack: "acme", Service: "api", Process: "web"}, true},
+		{rig.Descriptor{Stack: "acme", Service: "api"}, rig.Descriptor{Stack: "acme", Service: "api"}, true},
+		{rig.Descriptor{Stack: "acme", Service: "api", Process: "web"}, rig.Descriptor{Stack: "acme", Service: "api"}, false},
+		{rig.Descriptor{Stack: "acme", Service: "api"}, rig.Descriptor{Stack: "acme", Service: "website"}, false},
+		{rig.Descriptor{Stack: "acme"}, rig.Descriptor{Stack: "other", Service: "api"}, false},
+	}
+
+	for _, test := range tests {
+		if covers(&test.a, &test.b) != test.expected {
+			t.Errorf("Expected %+v covering %+v to be %v", test.a, test.b, test.expected)
+		}
+	}
+}
+
+func Test_DedupeDescriptors(t *testing.T) {
+	descriptors := []*rig.Descriptor{
+		{Stack: "acme", Service: "api", Process: "web"},
+		{Stack: "acme", Service: "api"},
+		{Stack: "acme", Service: "website"},
+		{Stack: "acme", Service: "api"},
+		{Stack: "other", Service: "api", Process: "web"},
+	}
+	kept := dedupeDescriptors(descriptors)
+
+	expected := []*rig.Descriptor{descriptors[1], descriptors[2], descriptors[4]}
+	if len(kept) != len(expected) {
+		t.Fatalf("Expected %d descriptors, got %d", len(expected), len(kept))
+	}
+	for i := range expected {
+		if kept[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", *expected[i], *kept[i])
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gocardless/rig"
+	"net"
+	"runtime"
+	"time"
+)
+
+func (c *Cli) CmdDNS(args ...string) error {
+	cmd := c.Subcmd("dns", "check", "Check that the hosts of the stacks resolve to this machine")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if cmd.NArg() != 1 || cmd.Arg(0) != "check" {
+		cmd.Usage()
+		return nil
+	}
+
+	body, _, err := c.call("GET", "/dns", nil)
+	if err != nil {
+		return err
+	}
+	var dns rig.ApiDNS
+	if err := json.Unmarshal(body, &dns); err != nil {
+		return fmt.Errorf("Error unmarshal: body: %s, err: %s\n", body, err)
+	}
+	fmt.Printf("rigd answers for %d hosts in .%s on %s\n", len(dns.Hosts), dns.Domain, dns.Addr)
+	if len(dns.Hosts) == 0 {
+		return fmt.Errorf("Error: there are no services to check")
+	}
+	host := dns.Hosts[0]
+
+	rigd := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", dns.Addr)
+		},
+	}
+	ok := check(fmt.Sprintf("rigd resolves %s", host), lookupLoopback(rigd, host))
+
+	missing := "rig-dns-check." + dns.Domain
+	_, err = lookup(rigd, missing)
+	if dnsErr, isDNSErr := err.(*net.DNSError); isDNSErr && dnsErr.IsNotFound {
+		err = nil
+	} else if err == nil {
+		err = fmt.Errorf("expected it not to exist")
+	}
+	ok = check(fmt.Sprintf("rigd doesn't resolve %s", missing), err) && ok
+
+	if !check(fmt.Sprintf("this machine resolves %s", host), lookupLoopback(net.DefaultResolver, host)) {
+		ok = false
+		_, port, _ := net.SplitHostPort(dns.Addr)
+		switch runtime.GOOS {
+		case "darwin":
+			fmt.Printf("\nCreate /etc/resolver/%s with these lines, so .%s hosts are resolved by rigd:\n\n", dns.Domain, dns.Domain)
+			fmt.Printf("    nameserver 127.0.0.1\n    port %s\n", port)
+		default:
+			fmt.Printf("\nPoint your resolver at 127.0.0.1 port %s for .%s hosts, e.g. with systemd-resolved\n", port, dns.Domain)
+			fmt.Printf("or dnsmasq (server=/%s/127.0.0.1#%s).\n", dns.Domain, port)
+		}
+	}
+
+	if !ok {
+		return fmt.Errorf("Error: DNS isn't working")
+	}
+	return nil
+}
+
+func check(description string, err error) bool {
+	if err != nil {
+		fmt.Printf("  FAIL  %s: %v\n", description, err)
+		return false
+	}
+	fmt.Printf("  ok    %s\n", description)
+	return true
+}
+
+func lookup(r *net.Resolver, host string) ([]net.IPAddr, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return r.LookupIPAddr(ctx, host)
+}
+
+func lookupLoopback(r *net.Resolver, host string) error {
+	addrs, err := lookup(r, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !addr.IP.IsLoopback() {
+			return fmt.Errorf("it resolves to %s", addr.IP)
+		}
+	}
+	return nil
+}
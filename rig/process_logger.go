@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"github.com/gocardless/rig"
 	"math"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 var (
@@ -17,8 +20,12 @@ var (
 	}
 	errorColor string = "\x1b[31m"
 
-	reset string = "\x1b[0m"
-	bold  string = "\x1b[1m"
+	reset     string = "\x1b[0m"
+	bold      string = "\x1b[1m"
+	boldReset string = "\x1b[22m"
+
+	highlight      string = "\x1b[7m"
+	highlightReset string = "\x1b[27m"
 )
 
 type ProcessLogger struct {
@@ -36,6 +43,81 @@ func NewProcessLogger() *ProcessLogger {
 }
 
 func (p *ProcessLogger) Println(m rig.ProcessOutputMessage) {
+	fmt.Println(p.format(m, content(m)))
+}
+
+// Structured messages are rendered as "LEVEL msg key=value", anything else
+// as it was output.
+func content(m rig.ProcessOutputMessage) string {
+	if m.Level == "" && m.Msg == "" {
+		return m.Content
+	}
+
+	str := fmt.Sprintf("%-5s %s", strings.ToUpper(m.Level), m.Msg)
+	if m.Level == "error" || m.Level == "fatal" {
+		str = bold + str + boldReset
+	}
+
+	keys := make([]string, 0, len(m.Fields))
+	for k := range m.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		str += fmt.Sprintf(" %s=%s", k, quoteValue(m.Fields[k]))
+	}
+	if m.Error != "" {
+		str += fmt.Sprintf(" error=%s", quoteValue(m.Error))
+	}
+	return str
+}
+
+func quoteValue(v string) string {
+	if strings.ContainsAny(v, " \t\n\"=") {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+// PrintHighlighted prints a message with the given [start, end) ranges of its
+// content highlighted.
+func (p *ProcessLogger) PrintHighlighted(m rig.ProcessOutputMessage, highlights [][]int) {
+	content := ""
+	last := 0
+	for _, h := range highlights {
+		content += m.Content[last:h[0]]
+		content += highlight + m.Content[h[0]:h[1]] + highlightReset
+		last = h[1]
+	}
+	content += m.Content[last:]
+
+	fmt.Println(p.format(m, content))
+}
+
+func (p *ProcessLogger) format(m rig.ProcessOutputMessage, content string) string {
+	color := p.color(m)
+
+	prefix := fmt.Sprintf("%s", color)
+	prefix += fmt.Sprintf("%s ", m.Time.Format("15:04:05"))
+
+	meta := fmt.Sprintf("%s:%s:%s", m.Stack, m.Service, m.Process)
+	p.maxMetaSize = math.Max(p.maxMetaSize, float64(len(meta)))
+	prefix += meta
+
+	prefix += fmt.Sprintf("%s | ", toSpace(p.maxMetaSize, meta))
+
+	// Every line of multi-line messages gets the prefix, to keep alignment
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		lines[i] = prefix + line + reset
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Every process gets its own color
+func (p *ProcessLogger) color(m rig.ProcessOutputMessage) string {
 	d := m.Stack + ":" + m.Service + ":" + m.Process
 	if p.processColor[d] == "" {
 		p.processColor[d] = colors[p.colorCounter]
@@ -44,20 +126,7 @@ func (p *ProcessLogger) Println(m rig.ProcessOutputMessage) {
 			p.colorCounter = 0
 		}
 	}
-	color := p.processColor[d]
-
-	str := fmt.Sprintf("%s", color)
-	str += fmt.Sprintf("%s ", m.Time.Format("15:04:05"))
-
-	meta := fmt.Sprintf("%s:%s:%s", m.Stack, m.Service, m.Process)
-	p.maxMetaSize = math.Max(p.maxMetaSize, float64(len(meta)))
-	str += meta
-
-	str += fmt.Sprintf("%s | ", toSpace(p.maxMetaSize, meta))
-	str += fmt.Sprintf("%s", m.Content)
-	str += fmt.Sprintf("%s", reset)
-
-	fmt.Println(str)
+	return p.processColor[d]
 }
 
 func toSpace(max float64, meta string) (spaces string) {
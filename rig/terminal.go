@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Terminal handling goes through stty, which is available everywhere rig
+// runs, rather than platform specific ioctls.
+
+const (
+	altScreenOn  = "\x1b[?1049h"
+	altScreenOff = "\x1b[?1049l"
+	hideCursor   = "\x1b[?25l"
+	showCursor   = "\x1b[?25h"
+	clearLine    = "\x1b[K"
+	cursorHome   = "\x1b[H"
+)
+
+type terminalState string
+
+// makeRaw puts the terminal in raw mode, returning the state to restore.
+// Output isn't post-processed in raw mode, lines must end with "\r\n".
+func makeRaw() (terminalState, error) {
+	return setTerminal("raw", "-echo")
+}
+
+// makeRawInput is like makeRaw, except output is still post-processed so
+// "\n" starts a new line.
+func makeRawInput() (terminalState, error) {
+	return setTerminal("raw", "-echo", "opost", "onlcr")
+}
+
+func setTerminal(settings ...string) (terminalState, error) {
+	state, err := stty("-g")
+	if err != nil {
+		return "", fmt.Errorf("Error: stdin isn't a terminal")
+	}
+	if _, err := stty(settings...); err != nil {
+		return "", err
+	}
+	return terminalState(strings.TrimSpace(state)), nil
+}
+
+func restoreTerminal(state terminalState) error {
+	_, err := stty(string(state))
+	return err
+}
+
+func terminalSize() (rows int, cols int, err error) {
+	out, err := stty("size")
+	if err != nil {
+		return 0, 0, err
+	}
+	_, err = fmt.Sscan(out, &rows, &cols)
+	return rows, cols, err
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
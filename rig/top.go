@@ -0,0 +1,582 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gocardless/rig"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	topMaxLogs       = 1000
+	topRefreshPeriod = time.Second
+)
+
+var topHelp = "j/k: select  s: start  x: stop  r: restart  /: filter  PgUp/PgDn: scroll logs  q: quit"
+
+// An entry of the dashboard's tree: a stack, a service or a process
+type topItem struct {
+	descriptor rig.Descriptor
+	process    *rig.ApiProcess
+}
+
+func (i *topItem) depth() int {
+	if i.descriptor.Process != "" {
+		return 2
+	} else if i.descriptor.Service != "" {
+		return 1
+	}
+	return 0
+}
+
+func (i *topItem) String() string {
+	return descriptorString(i.descriptor)
+}
+
+func descriptorString(d rig.Descriptor) string {
+	parts := []string{d.Stack}
+	if d.Service != "" {
+		parts = append(parts, d.Service)
+	}
+	if d.Process != "" {
+		parts = append(parts, d.Process)
+	}
+	return strings.Join(parts, ":")
+}
+
+// Top is a full screen dashboard showing every stack, service and process,
+// with a merged log of their output. It's entirely driven by the Run loop, so
+// needs no locking.
+type Top struct {
+	cli           *Cli
+	logger        *ProcessLogger
+	items         []*topItem
+	selected      string
+	logs          []rig.ProcessOutputMessage
+	scroll        int
+	filter        string
+	editingFilter bool
+	message       string
+	rows, cols    int
+	// Stacks being tailed, and whether they were tailed before
+	tailing map[string]bool
+	tailed  map[string]bool
+	logCh   chan rig.ProcessOutputMessage
+	tailCh  chan topTailEnd
+}
+
+// topTailEnd tells that the tail of a stack ended, with an error if it failed
+type topTailEnd struct {
+	stack string
+	err   error
+}
+
+func (c *Cli) CmdTop(args ...string) error {
+	cmd := c.Subcmd("top", "", "Show stacks, services, processes and logs in a dashboard")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+
+	if cmd.NArg() > 0 {
+		cmd.Usage()
+		return nil
+	}
+
+	t := &Top{
+		cli:     c,
+		logger:  NewProcessLogger(),
+		tailing: make(map[string]bool),
+		tailed:  make(map[string]bool),
+		logCh:   make(chan rig.ProcessOutputMessage, 100),
+		tailCh:  make(chan topTailEnd),
+	}
+	return t.Run()
+}
+
+func (t *Top) Run() error {
+	if err := t.refresh(); err != nil {
+		return err
+	}
+
+	state, err := makeRaw()
+	if err != nil {
+		return err
+	}
+	defer restoreTerminal(state)
+
+	fmt.Print(altScreenOn + hideCursor)
+	defer fmt.Print(showCursor + altScreenOff)
+
+	// The size is only read again when the terminal is resized
+	winchCh := make(chan os.Signal, 1)
+	signal.Notify(winchCh, syscall.SIGWINCH)
+	defer signal.Stop(winchCh)
+	t.resize()
+
+	t.tailStacks()
+
+	keyCh := make(chan string)
+	go readKeys(keyCh)
+
+	ticker := time.NewTicker(topRefreshPeriod)
+	defer ticker.Stop()
+
+	for {
+		t.render()
+
+		select {
+		case m := <-t.logCh:
+			t.addLog(m)
+		case end := <-t.tailCh:
+			delete(t.tailing, end.stack)
+			if end.err != nil {
+				t.message = fmt.Sprintf("Error tailing %s: %v", end.stack, end.err)
+			}
+		case <-winchCh:
+			t.resize()
+		case <-ticker.C:
+			if err := t.refresh(); err != nil {
+				t.message = err.Error()
+			}
+			t.tailStacks()
+		case key, ok := <-keyCh:
+			if !ok || t.handleKey(key) {
+				return nil
+			}
+		}
+
+		// Render once for a burst of output rather than for every line
+	Drain:
+		for {
+			select {
+			case m := <-t.logCh:
+				t.addLog(m)
+			default:
+				break Drain
+			}
+		}
+	}
+}
+
+// refresh fetches the tree of stacks, services and processes, and their status
+func (t *Top) refresh() error {
+	body, _, err := t.cli.call("GET", "/list", nil)
+	if err != nil {
+		return err
+	}
+	var list map[string]map[string][]string
+	if err := json.Unmarshal(body, &list); err != nil {
+		return err
+	}
+
+	body, _, err = t.cli.call("GET", "/ps", nil)
+	if err != nil {
+		return err
+	}
+	var ps map[string]map[string][]*rig.ApiProcess
+	if err := json.Unmarshal(body, &ps); err != nil {
+		return err
+	}
+
+	var items []*topItem
+	for _, stackName := range sortedKeys(list) {
+		items = append(items, &topItem{descriptor: rig.Descriptor{Stack: stackName}})
+
+		services := list[stackName]
+		serviceNames := make([]string, 0, len(services))
+		for name := range services {
+			serviceNames = append(serviceNames, name)
+		}
+		sort.Strings(serviceNames)
+
+		for _, serviceName := range serviceNames {
+			items = append(items, &topItem{descriptor: rig.Descriptor{Stack: stackName, Service: serviceName}})
+
+			processNames := services[serviceName]
+			sort.Strings(processNames)
+			for _, processName := range processNames {
+				item := &topItem{descriptor: rig.Descriptor{Stack: stackName, Service: serviceName, Process: processName}}
+				for _, p := range ps[stackName][serviceName] {
+					if p.Name == processName {
+						item.process = p
+					}
+				}
+				items = append(items, item)
+			}
+		}
+	}
+
+	t.items = items
+	if t.selected == "" && len(items) > 0 {
+		t.selected = items[0].String()
+	}
+	return nil
+}
+
+func (t *Top) stacks() []string {
+	var stacks []string
+	for _, item := range t.items {
+		if item.depth() == 0 {
+			stacks = append(stacks, item.descriptor.Stack)
+		}
+	}
+	return stacks
+}
+
+// tailStacks tails the stacks which aren't tailed, such as stacks added since
+// the last refresh or whose tail ended. Only new stacks get their last lines,
+// others would get them twice.
+func (t *Top) tailStacks() {
+	for _, stack := range t.stacks() {
+		if t.tailing[stack] {
+			continue
+		}
+		num := 50
+		if t.tailed[stack] {
+			num = 0
+		}
+		t.tailing[stack] = true
+		t.tailed[stack] = true
+
+		go func(stack string, num int) {
+			err := t.cli.streamMessages("POST", fmt.Sprintf("/%s/tail?num=%d&strip_ansi=true", stack, num), nil, func(m rig.ProcessOutputMessage) {
+				t.logCh <- m
+			})
+			t.tailCh <- topTailEnd{stack, err}
+		}(stack, num)
+	}
+}
+
+func (t *Top) resize() {
+	rows, cols, err := terminalSize()
+	if err != nil || rows < 10 {
+		rows, cols = 24, 80
+	}
+	t.rows, t.cols = rows, cols
+}
+
+func (t *Top) addLog(m rig.ProcessOutputMessage) {
+	t.logs = append(t.logs, m)
+	if len(t.logs) > topMaxLogs {
+		t.logs = t.logs[len(t.logs)-topMaxLogs:]
+	}
+}
+
+// visibleItems are the items matching the filter, along with their parents
+func (t *Top) visibleItems() []*topItem {
+	if t.filter == "" {
+		return t.items
+	}
+
+	var visible []*topItem
+	for i, item := range t.items {
+		match := false
+		for j, other := range t.items[i:] {
+			if j > 0 && !strings.HasPrefix(other.String(), item.String()+":") {
+				break
+			}
+			if t.matches(other.String()) {
+				match = true
+				break
+			}
+		}
+		if match {
+			visible = append(visible, item)
+		}
+	}
+	return visible
+}
+
+func (t *Top) visibleLogs() []rig.ProcessOutputMessage {
+	if t.filter == "" {
+		return t.logs
+	}
+
+	var visible []rig.ProcessOutputMessage
+	for _, m := range t.logs {
+		d := rig.Descriptor{Stack: m.Stack, Service: m.Service, Process: m.Process}
+		if t.matches(descriptorString(d)) || t.matches(m.Content) {
+			visible = append(visible, m)
+		}
+	}
+	return visible
+}
+
+func (t *Top) matches(str string) bool {
+	return strings.Contains(strings.ToLower(str), strings.ToLower(t.filter))
+}
+
+// handleKey acts on a key press, and returns whether to quit
+func (t *Top) handleKey(key string) bool {
+	if t.editingFilter {
+		switch key {
+		case "enter", "esc":
+			t.editingFilter = false
+		case "backspace":
+			if len(t.filter) > 0 {
+				t.filter = t.filter[:len(t.filter)-1]
+			}
+		case "ctrl-c":
+			return true
+		default:
+			if len(key) == 1 {
+				t.filter += key
+			}
+		}
+		return false
+	}
+
+	switch key {
+	case "q", "ctrl-c":
+		return true
+	case "j", "down":
+		t.moveSelection(1)
+	case "k", "up":
+		t.moveSelection(-1)
+	case "pgup":
+		t.scroll += 10
+	case "pgdn":
+		t.scroll -= 10
+		if t.scroll < 0 {
+			t.scroll = 0
+		}
+	case "/":
+		t.editingFilter = true
+	case "esc":
+		t.filter = ""
+	case "s":
+		t.act("start")
+	case "x":
+		t.act("stop")
+	case "r":
+		t.act("restart")
+	}
+	return false
+}
+
+func (t *Top) moveSelection(delta int) {
+	items := t.visibleItems()
+	if len(items) == 0 {
+		return
+	}
+
+	idx := t.selectedIndex(items) + delta
+	if idx < 0 {
+		idx = 0
+	} else if idx >= len(items) {
+		idx = len(items) - 1
+	}
+	t.selected = items[idx].String()
+}
+
+func (t *Top) selectedIndex(items []*topItem) int {
+	for i, item := range items {
+		if item.String() == t.selected {
+			return i
+		}
+	}
+	return 0
+}
+
+// act starts, stops or restarts the selected item
+func (t *Top) act(action string) {
+	items := t.visibleItems()
+	if len(items) == 0 {
+		return
+	}
+	item := items[t.selectedIndex(items)]
+
+	d := item.descriptor
+	path := "/" + d.Stack
+	if d.Service != "" {
+		path += "/" + d.Service
+	}
+	if d.Process != "" {
+		path += "/" + d.Process
+	}
+
+	if _, _, err := t.cli.call("POST", path+"/"+action, nil); err != nil {
+		t.message = err.Error()
+		return
+	}
+	t.message = fmt.Sprintf("%s: %s", action, item)
+}
+
+func (t *Top) render() {
+	rows, cols := t.rows, t.cols
+
+	var lines []string
+	lines = append(lines, bold+truncate("rig "+rig.Version+"  "+topHelp, cols)+reset)
+
+	// The tree takes up to half of the screen, scrolled to the selection
+	items := t.visibleItems()
+	treeHeight := rows/2 - 2
+	if len(items) < treeHeight {
+		treeHeight = len(items)
+	}
+	selected := t.selectedIndex(items)
+	first := 0
+	if selected >= treeHeight {
+		first = selected - treeHeight + 1
+	}
+
+	lines = append(lines, bold+truncate(fmt.Sprintf("%-40s %-8s %-7s %-9s %-6s %-7s %-8s", "NAME", "STATUS", "PID", "UPTIME", "PORT", "CPU", "MEM"), cols)+reset)
+	for i := first; i < first+treeHeight; i++ {
+		line := truncate(formatTopItem(items[i]), cols)
+		if i == selected {
+			line = highlight + line + highlightReset
+		}
+		lines = append(lines, line)
+	}
+
+	lines = append(lines, strings.Repeat("-", cols))
+
+	// The logs fill the rest of the screen but the status line
+	logHeight := rows - len(lines) - 1
+	var logLines []string
+	for _, m := range t.visibleLogs() {
+		for _, line := range strings.Split(content(m), "\n") {
+			d := rig.Descriptor{Stack: m.Stack, Service: m.Service, Process: m.Process}
+			str := fmt.Sprintf("%s %s | %s", m.Time.Format("15:04:05"), descriptorString(d), line)
+			logLines = append(logLines, t.logger.color(m)+truncate(str, cols)+reset)
+		}
+	}
+	if t.scroll > len(logLines)-logHeight {
+		t.scroll = len(logLines) - logHeight
+	}
+	if t.scroll < 0 {
+		t.scroll = 0
+	}
+	end := len(logLines) - t.scroll
+	start := end - logHeight
+	if start < 0 {
+		start = 0
+	}
+	lines = append(lines, logLines[start:end]...)
+	for len(lines) < rows-1 {
+		lines = append(lines, "")
+	}
+
+	status := t.message
+	if t.editingFilter || t.filter != "" {
+		status = "Filter: " + t.filter
+		if t.editingFilter {
+			status += "_"
+		}
+	}
+	lines = append(lines, truncate(status, cols))
+
+	out := cursorHome
+	for i, line := range lines {
+		out += line + clearLine
+		if i < len(lines)-1 {
+			out += "\r\n"
+		}
+	}
+	fmt.Print(out)
+}
+
+func formatTopItem(item *topItem) string {
+	names := []string{item.descriptor.Stack, item.descriptor.Service, item.descriptor.Process}
+	name := strings.Repeat("  ", item.depth()) + names[item.depth()]
+	if item.depth() < 2 {
+		return name
+	}
+
+	status, pid, uptime, port, cpu, mem := "Stopped", "", "", "", "", ""
+	if p := item.process; p != nil {
+		if p.NextRun != nil {
+			status = "Sched"
+		}
+		if p.Status == 1 {
+			status = "Running"
+			uptime = formatUptime(time.Since(p.StartedAt))
+			if r := p.Resources; r != nil {
+				cpu = fmt.Sprintf("%.1f%%", r.CPU)
+				mem = formatBytes(r.RSS)
+			}
+		}
+		if p.Status == 2 {
+			status = "Starting"
+		}
+		if p.Lazy != "" {
+			status = strings.Title(p.Lazy)
+		}
+		if p.Pid != 0 {
+			pid = strconv.Itoa(p.Pid)
+		}
+		if p.Port != 0 {
+			port = strconv.Itoa(p.Port)
+		}
+	}
+	return fmt.Sprintf("%-40s %-8s %-7s %-9s %-6s %-7s %-8s", name, status, pid, uptime, port, cpu, mem)
+}
+
+func formatUptime(d time.Duration) string {
+	d = d - d%time.Second
+	switch {
+	case d < time.Hour:
+		return d.String()
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return fmt.Sprintf("%dd%02dh", int(d.Hours())/24, int(d.Hours())%24)
+}
+
+func truncate(str string, width int) string {
+	if len(str) > width {
+		return str[:width]
+	}
+	return str
+}
+
+func sortedKeys(m map[string]map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// readKeys sends key presses read from the terminal, naming special keys
+func readKeys(keyCh chan string) {
+	special := map[string]string{
+		"\x1b[A":  "up",
+		"\x1b[B":  "down",
+		"\x1b[5~": "pgup",
+		"\x1b[6~": "pgdn",
+		"\x1b":    "esc",
+		"\r":      "enter",
+		"\x7f":    "backspace",
+		"\x03":    "ctrl-c",
+	}
+
+	buf := make([]byte, 16)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			close(keyCh)
+			return
+		}
+
+		chunk := string(buf[:n])
+		if key, exists := special[chunk]; exists {
+			keyCh <- key
+			continue
+		}
+		for _, r := range chunk {
+			if key, exists := special[string(r)]; exists {
+				keyCh <- key
+			} else {
+				keyCh <- string(r)
+			}
+		}
+	}
+}
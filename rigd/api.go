@@ -4,11 +4,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gocardless/rig"
+	"github.com/gocardless/rig/utils"
 	"github.com/gorilla/mux"
+	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type RouteHandler func(*Server, http.ResponseWriter, *http.Request, map[string]string) error
@@ -35,19 +42,41 @@ func makeRouter(srv *Server) (*mux.Router, error) {
 
 	mapRoutes := map[string][]map[string]RouteHandler{
 		"GET": {
+			{"/config": getConfig},
+			{"/dns": getDNS},
 			{"/list": getList},
+			{"/metrics": getMetrics},
 			{"/ps": getPs},
 			{"/resolve": getResolve},
+			{"/search": getSearch},
+			{"/ui": getUI},
 			{"/version": getVersion},
+			{"/{stack:.*}/{service:.*}/{process:.*}/command": getCommand},
+			{"/{stack:.*}/{service:.*}/{process:.*}/history": getProcessHistory},
+			{"/{stack:.*}/{service:.*}/{process:.*}/resources": getProcessResources},
+			{"/{stack:.*}/{service:.*}/{process:.*}/runs": getProcessRuns},
+			{"/{stack:.*}/{service:.*}/{process:.*}/url": getURL},
+			{"/{stack:.*}/{service:.*}/command": getCommand},
+			{"/{stack:.*}/{service:.*}/history": getServiceHistory},
+			{"/{stack:.*}/{service:.*}/tasks": getTasks},
+			{"/{stack:.*}/{service:.*}/url": getURL},
+			{"/{stack:.*}/history": getStackHistory},
 		},
 		"POST": {
+			{"/{stack:.*}/{service:.*}/{process:.*}/attach": postProcessAttach},
+			{"/{stack:.*}/{service:.*}/{process:.*}/restart": postProcessRestart},
+			{"/{stack:.*}/{service:.*}/{process:.*}/signal": postSignal},
 			{"/{stack:.*}/{service:.*}/{process:.*}/start": postProcessStart},
 			{"/{stack:.*}/{service:.*}/{process:.*}/stop": postProcessStop},
 			{"/{stack:.*}/{service:.*}/{process:.*}/tail": postProcessTail},
+			{"/{stack:.*}/{service:.*}/tasks/{task:.*}/run": postTaskRun},
+			{"/{stack:.*}/{service:.*}/restart": postServiceRestart},
+			{"/{stack:.*}/{service:.*}/signal": postSignal},
 			{"/{stack:.*}/{service:.*}/start": postServiceStart},
 			{"/{stack:.*}/{service:.*}/stop": postServiceStop},
 			{"/{stack:.*}/{service:.*}/tail": postServiceTail},
 			{"/{stack:.*}/restart": postStackRestart},
+			{"/{stack:.*}/signal": postSignal},
 			{"/{stack:.*}/start": postStackStart},
 			{"/{stack:.*}/stop": postStackStop},
 			{"/{stack:.*}/tail": postStackTail},
@@ -69,9 +98,11 @@ func makeRouter(srv *Server) (*mux.Router, error) {
 func registerRoute(srv *Server, r *mux.Router, method, route string, handlerFunc RouteHandler) {
 	log.Printf("Registring %s %s", method, route)
 	f := func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
 		if err := handlerFunc(srv, w, r, mux.Vars(r)); err != nil {
 			httpError(w, err)
 		}
+		srv.requestMetrics.Observe(method, route, time.Since(start))
 	}
 
 	r.Path(route).Methods(method).HandlerFunc(f)
@@ -82,6 +113,8 @@ func httpError(w http.ResponseWriter, err error) {
 		http.Error(w, err.Error(), http.StatusNotFound)
 	} else if strings.HasPrefix(err.Error(), "Bad parameter") {
 		http.Error(w, err.Error(), http.StatusBadRequest)
+	} else if strings.HasPrefix(err.Error(), "Forbidden") {
+		http.Error(w, err.Error(), http.StatusForbidden)
 	} else if strings.HasPrefix(err.Error(), "Impossible") {
 		http.Error(w, err.Error(), http.StatusNotAcceptable)
 	} else {
@@ -94,6 +127,42 @@ func writeJSON(w http.ResponseWriter, b []byte) {
 	w.Write(b)
 }
 
+func getConfig(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	b, err := json.MarshalIndent(srv.Config, "", "  ")
+	if err != nil {
+		return err
+	}
+	writeJSON(w, b)
+
+	return nil
+}
+
+func getDNS(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	dns := srv.dns
+	if dns == nil {
+		return fmt.Errorf("No such DNS server: add a dns section to the config")
+	}
+
+	b, err := json.Marshal(rig.ApiDNS{
+		Addr:    dns.conn.LocalAddr().String(),
+		Domain:  dns.Domain,
+		Forward: dns.Forward,
+		Hosts:   dns.Hosts(),
+	})
+	if err != nil {
+		return err
+	}
+	writeJSON(w, b)
+
+	return nil
+}
+
+func getUI(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(uiPage))
+	return nil
+}
+
 func getList(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	stacks := make(map[string]map[string][]string)
 	for stackName, s := range srv.Stacks {
@@ -116,6 +185,11 @@ func getList(srv *Server, w http.ResponseWriter, r *http.Request, vars map[strin
 	return nil
 }
 
+func getMetrics(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	return srv.WriteMetrics(w)
+}
+
 func getPs(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	stacks := make(map[string]map[string][]*rig.ApiProcess)
 	for stackName, s := range srv.Stacks {
@@ -123,11 +197,27 @@ func getPs(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]
 		for serviceName, svc := range s.Services {
 			processes := []*rig.ApiProcess{}
 			for _, p := range svc.Processes {
-				if p.Process != nil {
+				st := p.state()
+				if st.process != nil || st.status != Stopped || p.Scheduled() || p.LazyState() != "" {
 					apiProcess := &rig.ApiProcess{
-						Name:   p.Name,
-						Pid:    p.Process.Pid,
-						Status: int(p.Status),
+						Name:        p.Name,
+						Status:      int(st.status),
+						StartedAt:   st.startedAt,
+						Port:        p.Port(),
+						BufferLines: p.buffer.Len(),
+						BufferBytes: p.buffer.Size(),
+						ExitReason:  p.stats.ExitReason(),
+						Schedule:    p.Config.Schedule,
+						Lazy:        p.LazyState(),
+					}
+					if st.process != nil {
+						apiProcess.Pid = st.process.Pid
+					}
+					if st.status == Running {
+						apiProcess.Resources = p.resources.Latest()
+					}
+					if next, ok := p.NextRun(); ok {
+						apiProcess.NextRun = &next
 					}
 					processes = append(processes, apiProcess)
 				}
@@ -145,175 +235,517 @@ func getPs(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]
 	return nil
 }
 
-func getResolve(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+func getCommand(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
 	}
+	d := buildDescriptor(vars)
 
 	if err := r.ParseForm(); err != nil {
 		return err
 	}
 
-	descriptor := r.Form.Get("descriptor")
-	pwd := r.Form.Get("pwd")
-
-	d, err := srv.Resolve(descriptor, pwd)
+	command, err := srv.Command(d, r.Form.Get("command"))
 	if err != nil {
 		return err
 	}
 
-	b, err := json.Marshal(d)
+	b, err := json.Marshal(command)
 	if err != nil {
 		return err
 	}
 	writeJSON(w, b)
+
 	return nil
 }
 
-func getVersion(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
-	v := srv.Version()
-	b, err := json.Marshal(v)
+// postSignal sends the signal in the "signal" parameter to a process, or to
+// every running process of a service or a stack. With "group" set it's sent
+// to their process groups.
+func postSignal(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	sig, err := ParseSignal(r.Form.Get("signal"))
+	if err != nil {
+		return fmt.Errorf("Bad parameter: %v", err)
+	}
+
+	// Signalling what a glob matches only fails if nothing was signalled
+	signalled := []string{}
+	err = forEachDescriptor(srv, vars, func(d *rig.Descriptor) error {
+		sqds, err := srv.Signal(d, sig, r.Form.Get("group") == "true")
+		signalled = append(signalled, sqds...)
+		return err
+	})
+	if err != nil && len(signalled) == 0 {
+		return err
+	}
+
+	b, err := json.Marshal(signalled)
 	if err != nil {
 		return err
 	}
 	writeJSON(w, b)
-	return nil
-}
 
-func postStackRestart(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	return nil
 }
 
-func postProcessStart(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+func getURL(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
 	}
 	d := buildDescriptor(vars)
 
-	if err := srv.StartProcess(d); err != nil {
+	u, err := srv.URL(d)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(rig.ApiURL{URL: u})
+	if err != nil {
 		return err
 	}
+	writeJSON(w, b)
 
 	return nil
 }
 
-func postProcessStop(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+func getTasks(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
 	}
 	d := buildDescriptor(vars)
 
-	if err := srv.StopProcess(d); err != nil {
+	svc, err := srv.GetService(d)
+	if err != nil {
+		return err
+	}
+
+	tasks := []*rig.ApiTask{}
+	for _, t := range svc.Tasks {
+		tasks = append(tasks, &rig.ApiTask{Name: t.Name, Command: t.process.Cmd, Runs: t.Runs()})
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Name < tasks[j].Name })
+
+	b, err := json.Marshal(tasks)
+	if err != nil {
 		return err
 	}
+	writeJSON(w, b)
 
 	return nil
 }
 
-func postProcessTail(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+// postTaskRun runs a task, streaming its output and then its result. The task
+// keeps running if the client goes away.
+func postTaskRun(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
 	}
 	d := buildDescriptor(vars)
 
-	w.Header().Set("Content-Type", "application/json")
+	t, err := srv.GetTask(d, vars["task"])
+	if err != nil {
+		return err
+	}
 
-	num := 20
-	subCh := make(chan rig.ProcessOutputMessage, num)
-	srv.TailProcess(d, subCh, num)
+	subCh := make(chan rig.ProcessOutputMessage)
+	subs := []*ProcessOutputSubscription{t.process.outputDispatcher.Subscribe(subCh, nil)}
+	defer endSubscriptions(subs, subCh)
 
+	type result struct {
+		run *rig.ApiTaskRun
+		err error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		run, err := t.Run()
+		resultCh <- result{run, err}
+	}()
+
+	enc := json.NewEncoder(w)
+	started := false
 	for {
 		select {
 		case msg := <-subCh:
-			b, err := json.Marshal(msg)
-			if err != nil {
-				return err
+			if !started {
+				w.Header().Set("Content-Type", "application/json")
+				started = true
 			}
-			w.Write(b)
+			enc.Encode(rig.ApiTaskEvent{Output: &msg})
 			w.(http.Flusher).Flush()
+		case res := <-resultCh:
+			// Every line was received before the task finished
+			if res.err != nil {
+				if !started {
+					return res.err
+				}
+				res.run = &rig.ApiTaskRun{Task: t.Name, ExitCode: -1, Error: res.err.Error()}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			return enc.Encode(rig.ApiTaskEvent{Result: res.run})
 		}
 	}
+}
+
+func getResolve(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
 
+	descriptor := r.Form.Get("descriptor")
+	pwd := r.Form.Get("pwd")
+
+	// With all set, globs are allowed and every match is returned
+	var resolved interface{}
+	if r.Form.Get("all") == "true" {
+		descriptors, err := srv.ResolveAll(descriptor, pwd)
+		if err != nil {
+			return err
+		}
+		resolved = descriptors
+	} else {
+		d, err := srv.Resolve(descriptor, pwd)
+		if err != nil {
+			return err
+		}
+		resolved = d
+	}
+
+	b, err := json.Marshal(resolved)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, b)
 	return nil
 }
 
-func postServiceStart(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+func getSearch(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	d := &rig.Descriptor{
+		Stack:   r.Form.Get("stack"),
+		Service: r.Form.Get("service"),
+		Process: r.Form.Get("process"),
+	}
+
+	expr := r.Form.Get("query")
+	if expr == "" {
+		return fmt.Errorf("Bad parameter: empty query")
+	}
+	if r.Form.Get("regexp") == "" {
+		expr = "(?i)" + regexp.QuoteMeta(expr)
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("Bad parameter: invalid query '%s': %v", expr, err)
+	}
+	query := &SearchQuery{Pattern: pattern, Limit: 1000}
+
+	if str := r.Form.Get("since"); str != "" {
+		since, err := utils.ParseDuration(str)
+		if err != nil {
+			return fmt.Errorf("Bad parameter: invalid since '%s'", str)
+		}
+		query.Since = time.Now().Add(-since)
+	}
+
+	if str := r.Form.Get("context"); str != "" {
+		query.Context, err = strconv.Atoi(str)
+		if err != nil || query.Context < 0 {
+			return fmt.Errorf("Bad parameter: invalid context '%s'", str)
+		}
+	}
+
+	results, err := srv.Search(d, query)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, b)
+	return nil
+}
+
+func getVersion(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	v := srv.Version()
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, b)
+	return nil
+}
+
+func postStackRestart(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
 	}
-	d := buildDescriptor(vars)
+	return forEachDescriptor(srv, vars, func(d *rig.Descriptor) error {
+		return srv.RestartStack(d)
+	})
+}
+
+func postServiceRestart(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	return forEachDescriptor(srv, vars, func(d *rig.Descriptor) error {
+		return srv.RestartService(d)
+	})
+}
 
-	if err := srv.StartService(d); err != nil {
+func postProcessRestart(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	return forEachDescriptor(srv, vars, func(d *rig.Descriptor) error {
+		return srv.RestartProcess(d)
+	})
+}
+
+// checkPorts fails starting processes whose ports are taken, unless the
+// kill_conflicts parameter is set to kill whatever listens on them.
+func checkPorts(srv *Server, r *http.Request, d *rig.Descriptor) error {
+	if err := r.ParseForm(); err != nil {
 		return err
 	}
+	return srv.CheckPorts(d, r.Form.Get("kill_conflicts") == "true")
+}
 
-	return nil
+func postProcessStart(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	return forEachDescriptor(srv, vars, func(d *rig.Descriptor) error {
+		if err := checkPorts(srv, r, d); err != nil {
+			return err
+		}
+		return srv.StartProcess(d)
+	})
 }
 
-func postServiceStop(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+func postProcessStop(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
 	}
+	return forEachDescriptor(srv, vars, func(d *rig.Descriptor) error {
+		return srv.StopProcess(d)
+	})
+}
+
+// postProcessAttach takes over the connection, like an HTTP upgrade, to
+// stream the raw output of the process and forward what the client sends to
+// its stdin. The Rig-Tty header tells whether the process has a terminal.
+func postProcessAttach(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := checkAttachRequest(r); err != nil {
+		return err
+	}
 	d := buildDescriptor(vars)
 
-	if err := srv.StopService(d); err != nil {
+	p, err := srv.GetProcess(d)
+	if err != nil {
+		return err
+	}
+	if err := p.Attachable(); err != nil {
+		return err
+	}
+	num, err := tailLength(r, 10)
+	if err != nil {
 		return err
 	}
 
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return fmt.Errorf("Impossible to attach over this connection")
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.rig.raw-stream\r\nConnection: Upgrade\r\nUpgrade: rig-attach\r\nRig-Tty: %v\r\n\r\n", p.tty != nil)
+
+	// What the client sent along with the request is buffered
+	if err := p.Attach(struct {
+		io.Reader
+		io.Writer
+	}{buf.Reader, conn}, num); err != nil {
+		log.Printf("[P] %v\n", err)
+	}
 	return nil
 }
 
-func postServiceTail(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+// checkAttachRequest keeps web pages from typing into processes: browsers
+// can't send the Upgrade header, and send the Origin of the page.
+func checkAttachRequest(r *http.Request) error {
+	if r.Header.Get("Upgrade") != "rig-attach" {
+		return fmt.Errorf("Bad parameter: attaching needs the 'Upgrade: rig-attach' header")
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host != r.Host {
+			return fmt.Errorf("Forbidden: can't attach from %s", origin)
+		}
+	}
+	return nil
+}
+
+func postProcessTail(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
 	}
 	d := buildDescriptor(vars)
 
-	w.Header().Set("Content-Type", "application/json")
+	return streamOutput(w, r, func(c chan rig.ProcessOutputMessage, num int, filter *LogFilter) ([]*rig.ProcessOutputMessage, []*ProcessOutputSubscription, error) {
+		if hasGlob(d) {
+			return srv.TailMatching(d, c, num, filter)
+		}
+		return srv.TailProcess(d, c, num, filter)
+	})
+}
 
-	num := 20
-	subCh := make(chan rig.ProcessOutputMessage, num)
-	srv.TailService(d, subCh, num)
+func getProcessHistory(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	d := buildDescriptor(vars)
 
-	for {
-		select {
-		case msg := <-subCh:
-			b, err := json.Marshal(msg)
-			if err != nil {
-				return err
-			}
-			w.Write(b)
-			w.(http.Flusher).Flush()
+	return writeHistory(w, r, func(num int, filter *LogFilter) ([]*rig.ProcessOutputMessage, error) {
+		if hasGlob(d) {
+			return srv.HistoryMatching(d, num, filter)
 		}
+		return srv.HistoryProcess(d, num, filter)
+	})
+}
+
+func getProcessResources(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	d := buildDescriptor(vars)
+
+	p, err := srv.GetProcess(d)
+	if err != nil {
+		return err
 	}
 
+	b, err := json.Marshal(p.resources.Samples())
+	if err != nil {
+		return err
+	}
+	writeJSON(w, b)
+
 	return nil
 }
 
-func postStackStart(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+func getProcessRuns(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
 	}
 	d := buildDescriptor(vars)
 
-	if err := srv.StartStack(d); err != nil {
+	p, err := srv.GetProcess(d)
+	if err != nil {
 		return err
 	}
 
+	b, err := json.Marshal(p.runs.Runs())
+	if err != nil {
+		return err
+	}
+	writeJSON(w, b)
+
 	return nil
 }
 
-func postStackStop(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+func postServiceStart(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	return forEachDescriptor(srv, vars, func(d *rig.Descriptor) error {
+		if err := checkPorts(srv, r, d); err != nil {
+			return err
+		}
+		return srv.StartService(d)
+	})
+}
+
+func postServiceStop(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	return forEachDescriptor(srv, vars, func(d *rig.Descriptor) error {
+		return srv.StopService(d)
+	})
+}
+
+func postServiceTail(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
 	}
 	d := buildDescriptor(vars)
 
-	if err := srv.StopStack(d); err != nil {
-		return err
+	return streamOutput(w, r, func(c chan rig.ProcessOutputMessage, num int, filter *LogFilter) ([]*rig.ProcessOutputMessage, []*ProcessOutputSubscription, error) {
+		if hasGlob(d) {
+			return srv.TailMatching(d, c, num, filter)
+		}
+		return srv.TailService(d, c, num, filter)
+	})
+}
+
+func getServiceHistory(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
 	}
+	d := buildDescriptor(vars)
 
-	return nil
+	return writeHistory(w, r, func(num int, filter *LogFilter) ([]*rig.ProcessOutputMessage, error) {
+		if hasGlob(d) {
+			return srv.HistoryMatching(d, num, filter)
+		}
+		return srv.HistoryService(d, num, filter)
+	})
+}
+
+func postStackStart(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	return forEachDescriptor(srv, vars, func(d *rig.Descriptor) error {
+		if err := checkPorts(srv, r, d); err != nil {
+			return err
+		}
+		return srv.StartStack(d)
+	})
+}
+
+func postStackStop(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	return forEachDescriptor(srv, vars, func(d *rig.Descriptor) error {
+		return srv.StopStack(d)
+	})
 }
 
 func postStackTail(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
@@ -322,33 +754,192 @@ func postStackTail(srv *Server, w http.ResponseWriter, r *http.Request, vars map
 	}
 	d := buildDescriptor(vars)
 
+	return streamOutput(w, r, func(c chan rig.ProcessOutputMessage, num int, filter *LogFilter) ([]*rig.ProcessOutputMessage, []*ProcessOutputSubscription, error) {
+		if hasGlob(d) {
+			return srv.TailMatching(d, c, num, filter)
+		}
+		return srv.TailStack(d, c, num, filter)
+	})
+}
+
+func getStackHistory(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	d := buildDescriptor(vars)
+
+	return writeHistory(w, r, func(num int, filter *LogFilter) ([]*rig.ProcessOutputMessage, error) {
+		if hasGlob(d) {
+			return srv.HistoryMatching(d, num, filter)
+		}
+		return srv.HistoryStack(d, num, filter)
+	})
+}
+
+func postConfigReload(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	err := srv.ReloadConfig()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+type subscribeFunc func(c chan rig.ProcessOutputMessage, num int, filter *LogFilter) ([]*rig.ProcessOutputMessage, []*ProcessOutputSubscription, error)
+
+// Messages buffered for a tail's client, beyond which new ones are dropped
+const tailBuffer = 1000
+
+// streamOutput streams the output selected by the request's filter
+// parameters until the client goes away.
+func streamOutput(w http.ResponseWriter, r *http.Request, subscribe subscribeFunc) error {
+	filter, err := NewLogFilter(r.URL.Query())
+	if err != nil {
+		return err
+	}
+	num, err := tailLength(r, 20)
+	if err != nil {
+		return err
+	}
+
+	subCh := make(chan rig.ProcessOutputMessage, tailBuffer)
+	history, subs, err := subscribe(subCh, num, filter)
+	if err != nil {
+		return err
+	}
+	defer endSubscriptions(subs, subCh)
+
 	w.Header().Set("Content-Type", "application/json")
 
-	num := 20
-	subCh := make(chan rig.ProcessOutputMessage, num)
-	srv.TailStack(d, subCh, num)
+	// One message per line, so browsers can split the stream
+	writeMessage := func(msg *rig.ProcessOutputMessage) bool {
+		b, err := json.Marshal(msg)
+		if err != nil {
+			return false
+		}
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			return false
+		}
+		return true
+	}
+
+	// Lines published while the history was read are in both
+	sent := make(map[outputKey]bool)
+	for _, msg := range history {
+		sent[keyOf(*msg)] = true
+		if !writeMessage(msg) {
+			return nil
+		}
+	}
+	w.(http.Flusher).Flush()
 
 	for {
 		select {
 		case msg := <-subCh:
-			b, err := json.Marshal(msg)
-			if err != nil {
-				return err
+			if len(sent) > 0 && sent[keyOf(msg)] {
+				delete(sent, keyOf(msg))
+				continue
+			}
+			if !writeMessage(&msg) {
+				return nil
 			}
-			w.Write(b)
 			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return nil
 		}
 	}
+}
 
-	return nil
+type outputKey struct {
+	stack, service, process, stream, content string
+	time                                     int64
 }
 
-func postConfigReload(srv *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
-	err := srv.ReloadConfig()
+func keyOf(m rig.ProcessOutputMessage) outputKey {
+	return outputKey{m.Stack, m.Service, m.Process, m.Stream, m.Content, m.Time.UnixNano()}
+}
+
+// Publishers may be blocked sending to c while we unsubscribe, so keep
+// draining it until we're done.
+func endSubscriptions(subs []*ProcessOutputSubscription, c chan rig.ProcessOutputMessage) {
+	done := make(chan bool)
+	go func() {
+		for _, s := range subs {
+			s.End()
+		}
+		close(done)
+	}()
+
+	for {
+		select {
+		case <-c:
+		case <-done:
+			return
+		}
+	}
+}
+
+type historyFunc func(num int, filter *LogFilter) ([]*rig.ProcessOutputMessage, error)
+
+func writeHistory(w http.ResponseWriter, r *http.Request, history historyFunc) error {
+	filter, err := NewLogFilter(r.URL.Query())
+	if err != nil {
+		return err
+	}
+	num, err := tailLength(r, 100)
+	if err != nil {
+		return err
+	}
+
+	messages, err := history(num, filter)
 	if err != nil {
 		return err
 	}
 
+	b, err := json.Marshal(messages)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, b)
+	return nil
+}
+
+// Number of past lines requested with the "num" parameter
+func tailLength(r *http.Request, def int) (int, error) {
+	str := r.URL.Query().Get("num")
+	if str == "" {
+		return def, nil
+	}
+
+	num, err := strconv.Atoi(str)
+	if err != nil || num < 0 {
+		return 0, fmt.Errorf("Bad parameter: invalid num '%s'", str)
+	}
+	return num, nil
+}
+
+// forEachDescriptor calls f with the descriptor of the route or, when it has
+// globs such as "*", with every stack, service or process they match. It
+// carries on when f fails for some.
+func forEachDescriptor(srv *Server, vars map[string]string, f func(d *rig.Descriptor) error) error {
+	d := buildDescriptor(vars)
+	if !hasGlob(d) {
+		return f(d)
+	}
+
+	ds, err := srv.ResolveAll(descriptorString(d), "")
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for _, d := range ds {
+		if err := f(d); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
 	return nil
 }
 
@@ -359,3 +950,20 @@ func buildDescriptor(vars map[string]string) *rig.Descriptor {
 		Process: vars["process"],
 	}
 }
+
+func hasGlob(d *rig.Descriptor) bool {
+	return isGlob(d.Stack) || isGlob(d.Service) || isGlob(d.Process)
+}
+
+// descriptorString joins the parts of a descriptor the way the resolver
+// expects them, e.g. "acme:*:worker"
+func descriptorString(d *rig.Descriptor) string {
+	parts := []string{d.Stack}
+	if d.Service != "" {
+		parts = append(parts, d.Service)
+	}
+	if d.Process != "" {
+		parts = append(parts, d.Process)
+	}
+	return strings.Join(parts, ":")
+}
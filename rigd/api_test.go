@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gocardless/rig"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_StreamOutputSkipsDuplicates(t *testing.T) {
+	now := time.Now()
+	a := rig.ProcessOutputMessage{Content: "a", Process: "web", Time: now}
+	b := rig.ProcessOutputMessage{Content: "b", Process: "web", Time: now.Add(1)}
+	c := rig.ProcessOutputMessage{Content: "c", Process: "web", Time: now.Add(2)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest("POST", "/acme/api/web/tail", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	// b was published while the history was read
+	err := streamOutput(w, r, func(ch chan rig.ProcessOutputMessage, num int, filter *LogFilter) ([]*rig.ProcessOutputMessage, []*ProcessOutputSubscription, error) {
+		ch <- b
+		ch <- c
+		go func() {
+			for len(ch) > 0 {
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+		}()
+		return []*rig.ProcessOutputMessage{&a, &b}, nil, nil
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var contents []string
+	dec := json.NewDecoder(w.Body)
+	for dec.More() {
+		var msg rig.ProcessOutputMessage
+		if err := dec.Decode(&msg); err != nil {
+			t.Fatalf("Expected JSON messages, got %v", err)
+		}
+		contents = append(contents, msg.Content)
+	}
+	if strings.Join(contents, "") != "abc" {
+		t.Errorf("Expected a, b and c once each, got %v", contents)
+	}
+}
+
+func Test_TailDoesntBlockProcesses(t *testing.T) {
+	svc := &Service{Name: "api", Stack: NewStack("acme"), Config: &ServiceConfig{}}
+	p := NewProcess("web", "true", svc)
+
+	// Nothing reads from the channel, like a client which stopped reading
+	ch := make(chan rig.ProcessOutputMessage)
+	_, subs := p.SubscribeToOutput(ch, 10, nil)
+	defer subs[0].End()
+
+	done := make(chan bool)
+	go func() {
+		p.publish("line", "stdout", time.Now())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected publishing not to wait for the tail")
+	}
+
+	if history := p.History(10, nil); len(history) != 1 || history[0].Content != "line" {
+		t.Errorf("Expected the line in the history, got %v", history)
+	}
+}
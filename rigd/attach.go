@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// A RawOutputDispatcher copies the output of a process, as it's read and
+// before it's split into lines, to attached clients. This way they see
+// prompts which don't end with a newline. Clients which can't keep up miss
+// output.
+type RawOutputDispatcher struct {
+	sync.Mutex
+	clients map[chan []byte]bool
+}
+
+func NewRawOutputDispatcher() *RawOutputDispatcher {
+	return &RawOutputDispatcher{clients: make(map[chan []byte]bool)}
+}
+
+func (d *RawOutputDispatcher) Write(b []byte) (int, error) {
+	d.Lock()
+	defer d.Unlock()
+	if len(d.clients) == 0 {
+		return len(b), nil
+	}
+
+	chunk := append([]byte{}, b...)
+	for c := range d.clients {
+		select {
+		case c <- chunk:
+		default:
+		}
+	}
+	return len(b), nil
+}
+
+func (d *RawOutputDispatcher) Subscribe() chan []byte {
+	c := make(chan []byte, 256)
+	d.Lock()
+	d.clients[c] = true
+	d.Unlock()
+	return c
+}
+
+func (d *RawOutputDispatcher) Unsubscribe(c chan []byte) {
+	d.Lock()
+	delete(d.clients, c)
+	d.Unlock()
+}
+
+// Attachable returns an error if the process can't be attached to: it must
+// be running, with a terminal or a stdin pipe.
+func (p *Process) Attachable() error {
+	_, err := p.attachState()
+	return err
+}
+
+// attachState returns the state of the running process to attach to
+func (p *Process) attachState() (processState, error) {
+	st := p.state()
+	if st.status != Running {
+		return st, fmt.Errorf("Can't attach: %s isn't running", p.Sqd())
+	}
+	if st.stdin == nil {
+		return st, fmt.Errorf("Can't attach: %s has no stdin, it needs the stdin or tty option", p.Sqd())
+	}
+	return st, nil
+}
+
+// Attach writes the last num lines of output of the process to conn, then
+// its output as it comes, and writes what's read from conn to its stdin. It
+// returns when the process exits or conn is closed.
+func (p *Process) Attach(conn io.ReadWriter, num int) error {
+	st, err := p.attachState()
+	if err != nil {
+		return err
+	}
+	stdin, done := st.stdin, st.done
+
+	output := p.rawOutput.Subscribe()
+	defer p.rawOutput.Unsubscribe(output)
+
+	for _, msg := range p.History(num, nil) {
+		if _, err := io.WriteString(conn, msg.Content+"\n"); err != nil {
+			return nil
+		}
+	}
+
+	detached := make(chan bool)
+	go func() {
+		io.Copy(stdin, conn)
+		close(detached)
+	}()
+
+	for {
+		select {
+		case b := <-output:
+			if _, err := conn.Write(b); err != nil {
+				return nil
+			}
+		case <-detached:
+			return nil
+		case <-done:
+			return nil
+		}
+	}
+}
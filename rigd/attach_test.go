@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_ProcessAttach(t *testing.T) {
+	svc := &Service{Name: "api", Stack: NewStack("acme"), Config: &ServiceConfig{}}
+	p := NewProcess("console", "cat", svc)
+
+	if err := p.Attachable(); err == nil {
+		t.Errorf("Expected an error attaching to a stopped process")
+	}
+
+	p.Config.Stdin = true
+	go p.Start()
+	for i := 0; i < 200 && p.Attachable() != nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client, server := net.Pipe()
+	attached := make(chan error)
+	go func() {
+		attached <- p.Attach(server, 0)
+	}()
+
+	if _, err := client.Write([]byte("hello\n")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// A login shell can print more, such as a motd
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(client)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Expected the process to echo 'hello', got %v", err)
+		}
+		if line == "hello\n" {
+			break
+		}
+	}
+
+	p.Stop()
+	select {
+	case err := <-attached:
+		if err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected attaching to end when the process exits")
+	}
+}
+
+func Test_CheckAttachRequest(t *testing.T) {
+	tests := []struct {
+		upgrade, origin string
+		ok              bool
+	}{
+		{"rig-attach", "", true},
+		{"rig-attach", "http://localhost:9696", true},
+		{"", "", false},
+		{"tcp", "", false},
+		{"rig-attach", "http://evil.example", false},
+		{"rig-attach", "null", false},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "http://localhost:9696/acme/api/web/attach", nil)
+		if test.upgrade != "" {
+			r.Header.Set("Upgrade", test.upgrade)
+		}
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if err := checkAttachRequest(r); (err == nil) != test.ok {
+			t.Errorf("Expected Upgrade %q and Origin %q to be allowed: %v, got %v", test.upgrade, test.origin, test.ok, err)
+		}
+	}
+}
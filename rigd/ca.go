@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A CA signs the certificates of the hosts served by the proxy over HTTPS.
+// Its key and certificate are generated once and kept in a directory, so
+// that it only has to be trusted once. Host certificates are generated when
+// they're first needed.
+type CA struct {
+	sync.Mutex
+	CertFile string
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	hosts    map[string]*tls.Certificate
+}
+
+func LoadCA(dir string) (*CA, error) {
+	ca := &CA{
+		CertFile: filepath.Join(dir, "ca.pem"),
+		hosts:    make(map[string]*tls.Certificate),
+	}
+	keyFile := filepath.Join(dir, "ca-key.pem")
+
+	if _, err := os.Stat(ca.CertFile); os.IsNotExist(err) {
+		if err := ca.generate(dir, keyFile); err != nil {
+			return nil, fmt.Errorf("Error generating a CA: %v", err)
+		}
+		return ca, nil
+	}
+
+	pair, err := tls.LoadX509KeyPair(ca.CertFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading the CA: %v", err)
+	}
+	if ca.cert, err = x509.ParseCertificate(pair.Certificate[0]); err != nil {
+		return nil, fmt.Errorf("Error loading the CA: %v", err)
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("Error loading the CA: %s isn't an ECDSA key", keyFile)
+	}
+	ca.key = key
+	return ca, nil
+}
+
+func (ca *CA) generate(dir, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "rig development CA", Organization: []string{"rig"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	if ca.cert, err = x509.ParseCertificate(der); err != nil {
+		return err
+	}
+	ca.key = key
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(ca.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// Certificate returns a certificate for host, signed by the CA
+func (ca *CA) Certificate(host string) (*tls.Certificate, error) {
+	ca.Lock()
+	defer ca.Unlock()
+
+	if cert, exists := ca.hosts[host]; exists && time.Now().Before(cert.Leaf.NotAfter) {
+		return cert, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	// Browsers reject certificates valid for more than 398 days
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host, Organization: []string{"rig"}},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(0, 0, 390),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	cert := &tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	ca.hosts[host] = cert
+	return cert, nil
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const cgroupCPUPeriod = 100000
+
+var (
+	cgroupRootOnce sync.Once
+	cgroupRoot     string
+	cgroupRootErr  error
+)
+
+// A cgroup v2 a process is started in, to enforce its memory, CPU and pids
+// limits.
+type Cgroup struct {
+	path string
+	dir  *os.File
+}
+
+// NewCgroup creates the cgroup of a process, with the given limits, in the
+// cgroup rigd was started in.
+func NewCgroup(name string, limits *ProcessLimits) (*Cgroup, error) {
+	cgroupRootOnce.Do(func() {
+		cgroupRoot, cgroupRootErr = setupCgroupRoot()
+		if cgroupRootErr != nil {
+			log.Printf("[P] cgroup limits are unavailable: %v\n", cgroupRootErr)
+		}
+	})
+	if cgroupRootErr != nil {
+		return nil, cgroupRootErr
+	}
+
+	path := filepath.Join(cgroupRoot, name)
+	// Left behind by a previous run of the process
+	os.Remove(path)
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, err
+	}
+
+	settings := map[string]string{}
+	if limits.Memory > 0 {
+		settings["memory.max"] = strconv.FormatUint(limits.Memory, 10)
+	}
+	if limits.CPU > 0 {
+		settings["cpu.max"] = fmt.Sprintf("%d %d", int(limits.CPU*cgroupCPUPeriod), cgroupCPUPeriod)
+	}
+	if limits.Pids > 0 {
+		settings["pids.max"] = strconv.Itoa(limits.Pids)
+	}
+	for file, value := range settings {
+		if err := writeCgroupFile(path, file, value); err != nil {
+			os.Remove(path)
+			return nil, err
+		}
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return &Cgroup{path: path, dir: dir}, nil
+}
+
+// Apply makes the process start in the cgroup
+func (c *Cgroup) Apply(attr *syscall.SysProcAttr) {
+	attr.UseCgroupFD = true
+	attr.CgroupFD = int(c.dir.Fd())
+}
+
+// OOMKills is the number of processes of the cgroup the kernel killed for
+// going over the memory limit.
+func (c *Cgroup) OOMKills() int {
+	f, err := os.Open(filepath.Join(c.path, "memory.events"))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			n, _ := strconv.Atoi(fields[1])
+			return n
+		}
+	}
+	return 0
+}
+
+// Close removes the cgroup, which fails if any of its processes are still
+// running; it's removed when the process next starts instead.
+func (c *Cgroup) Close() {
+	c.dir.Close()
+	os.Remove(c.path)
+}
+
+// setupCgroupRoot prepares the cgroup rigd was started in for holding the
+// cgroups of processes. The cgroup has to be delegated to the user running
+// rigd (Delegate=yes with systemd). As cgroups with controllers enabled for
+// their children can't hold processes themselves, rigd moves into a child
+// cgroup of its own first.
+func setupCgroupRoot() (string, error) {
+	mount, err := cgroup2Mount()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	own, err := parseCgroup2Path(string(data))
+	if err != nil {
+		return "", err
+	}
+	root := filepath.Join(mount, own)
+
+	controllers, err := ioutil.ReadFile(filepath.Join(root, "cgroup.controllers"))
+	if err != nil {
+		return "", err
+	}
+	var enable []string
+	for _, controller := range strings.Fields(string(controllers)) {
+		switch controller {
+		case "memory", "cpu", "pids":
+			enable = append(enable, "+"+controller)
+		}
+	}
+	if len(enable) == 0 {
+		return "", fmt.Errorf("no memory, cpu or pids controllers in %s", root)
+	}
+
+	daemon := filepath.Join(root, "rigd")
+	if err := os.Mkdir(daemon, 0755); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	if err := writeCgroupFile(daemon, "cgroup.procs", strconv.Itoa(os.Getpid())); err != nil {
+		return "", err
+	}
+	if err := writeCgroupFile(root, "cgroup.subtree_control", strings.Join(enable, " ")); err != nil {
+		return "", fmt.Errorf("%v (is the cgroup delegated and used by rigd only?)", err)
+	}
+
+	return root, nil
+}
+
+func cgroup2Mount() (string, error) {
+	data, err := ioutil.ReadFile("/proc/mounts")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && fields[2] == "cgroup2" {
+			return fields[1], nil
+		}
+	}
+	return "", fmt.Errorf("cgroup v2 isn't mounted")
+}
+
+// The cgroup v2 of a process is on the line of /proc/<pid>/cgroup with
+// hierarchy 0 and no controllers: "0::/path"
+func parseCgroup2Path(data string) (string, error) {
+	for _, line := range strings.Split(data, "\n") {
+		if strings.HasPrefix(line, "0::") {
+			return strings.TrimPrefix(line, "0::"), nil
+		}
+	}
+	return "", fmt.Errorf("not in a cgroup v2")
+}
+
+func writeCgroupFile(dir, file, value string) error {
+	if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(value), 0644); err != nil {
+		return fmt.Errorf("error writing %s: %v", file, err)
+	}
+	return nil
+}
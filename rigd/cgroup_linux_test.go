@@ -0,0 +1,20 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_ParseCgroup2Path(t *testing.T) {
+	data := "12:memory:/user.slice\n0::/user.slice/user-1000.slice/rigd.service\n"
+	path, err := parseCgroup2Path(data)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if path != "/user.slice/user-1000.slice/rigd.service" {
+		t.Errorf("Expected the unified hierarchy's path, got %s", path)
+	}
+
+	if _, err := parseCgroup2Path("4:memory:/foo\n"); err == nil {
+		t.Errorf("Expected an error without a unified hierarchy")
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+type Cgroup struct{}
+
+func NewCgroup(name string, limits *ProcessLimits) (*Cgroup, error) {
+	return nil, fmt.Errorf("cgroups are only supported on Linux")
+}
+
+func (c *Cgroup) Apply(attr *syscall.SysProcAttr) {}
+
+func (c *Cgroup) OOMKills() int {
+	return 0
+}
+
+func (c *Cgroup) Close() {}
@@ -17,16 +17,164 @@ const defaultConfig = `
 `
 
 type Config struct {
-	Filename string
-	Stacks   map[string]*StackConfig `json:"stacks,omitempty"`
+	Filename  string
+	LogBuffer *LogBufferConfig        `json:"log_buffer,omitempty"`
+	LogStore  *LogStoreConfig         `json:"log_store,omitempty"`
+	Sinks     []*SinkConfig           `json:"sinks,omitempty"`
+	Proxy     *ProxyConfig            `json:"proxy,omitempty"`
+	DNS       *DNSConfig              `json:"dns,omitempty"`
+	Stacks    map[string]*StackConfig `json:"stacks,omitempty"`
 }
 
 type StackConfig struct {
 	Services map[string]*ServiceConfig `json:"services,omitempty"`
 }
 
+// StartTasks are names of Tasks, run in order before the processes of the
+// service are started.
 type ServiceConfig struct {
-	Dir string `json:"dir,omitempty"`
+	Dir        string                    `json:"dir,omitempty"`
+	Port       int                       `json:"port,omitempty"`
+	LogBuffer  *LogBufferConfig          `json:"log_buffer,omitempty"`
+	Multiline  *MultilineConfig          `json:"multiline,omitempty"`
+	Limits     *LimitsConfig             `json:"limits,omitempty"`
+	Hooks      *HooksConfig              `json:"hooks,omitempty"`
+	Lazy       *LazyConfig               `json:"lazy,omitempty"`
+	Tasks      map[string]string         `json:"tasks,omitempty"`
+	StartTasks []string                  `json:"start_tasks,omitempty"`
+	Processes  map[string]*ProcessConfig `json:"processes,omitempty"`
+}
+
+// With TTY set, a process runs under a pseudo-terminal of TTYSize, such as
+// "120x40" (80x24 by default). With Stdin set, its stdin is a pipe held open
+// by rigd instead of /dev/null. Either lets rig attach write to it.
+type ProcessConfig struct {
+	Port      int              `json:"port,omitempty"`
+	LogBuffer *LogBufferConfig `json:"log_buffer,omitempty"`
+	LogFormat string           `json:"log_format,omitempty"`
+	Multiline *MultilineConfig `json:"multiline,omitempty"`
+	Limits    *LimitsConfig    `json:"limits,omitempty"`
+	Hooks     *HooksConfig     `json:"hooks,omitempty"`
+	Schedule  string           `json:"schedule,omitempty"`
+	Watch     *WatchConfig     `json:"watch,omitempty"`
+	TTY       bool             `json:"tty,omitempty"`
+	TTYSize   string           `json:"tty_size,omitempty"`
+	Stdin     bool             `json:"stdin,omitempty"`
+	Lazy      *LazyConfig      `json:"lazy,omitempty"`
+}
+
+// Rules grouping several lines of output, such as a stack trace, into one
+// message. See MultilineRules.
+type MultilineConfig struct {
+	Indent   bool   `json:"indent,omitempty"`
+	Start    string `json:"start,omitempty"`
+	Timeout  string `json:"timeout,omitempty"`
+	MaxLines int    `json:"max_lines,omitempty"`
+}
+
+// Limits applied to a process when it starts. See ProcessLimits.
+type LimitsConfig struct {
+	NoFile       uint64  `json:"nofile,omitempty"`
+	AddressSpace string  `json:"address_space,omitempty"`
+	CPUTime      string  `json:"cpu_time,omitempty"`
+	Memory       string  `json:"memory,omitempty"`
+	CPU          float64 `json:"cpu,omitempty"`
+	Pids         int     `json:"pids,omitempty"`
+}
+
+// Commands run around starting and stopping a process. A failing PreStart
+// hook prevents the process from starting; the others are only logged.
+// PostStart runs as soon as the process is spawned, not once it's ready.
+// Hooks running for longer than Timeout (e.g. "2m") are killed.
+type HooksConfig struct {
+	PreStart  string `json:"pre_start,omitempty"`
+	PostStart string `json:"post_start,omitempty"`
+	PreStop   string `json:"pre_stop,omitempty"`
+	PostStop  string `json:"post_stop,omitempty"`
+	Timeout   string `json:"timeout,omitempty"`
+}
+
+// Files watched for changes which restart a process, or send it Signal
+// (e.g. "HUP") instead. See WatchRules.
+type WatchConfig struct {
+	Paths    []string `json:"paths"`
+	Ignore   []string `json:"ignore,omitempty"`
+	Debounce string   `json:"debounce,omitempty"`
+	Interval string   `json:"interval,omitempty"`
+	Signal   string   `json:"signal,omitempty"`
+}
+
+// A lazy process only starts on the first connection to its port, and stops
+// again after IdleTimeout ("15m" by default) without any. It has StartTimeout
+// ("60s" by default) to accept connections. See LazyRules.
+type LazyConfig struct {
+	IdleTimeout  string `json:"idle_timeout,omitempty"`
+	StartTimeout string `json:"start_timeout,omitempty"`
+}
+
+// The proxy routes requests for <service>.<stack>.<Domain> to the web
+// process of the service, and <process>.<service>.<stack>.<Domain> to that
+// process. It listens on Addr (":80" by default) and, with HTTPS set, on
+// HTTPSAddr (":443" by default) with certificates signed by a CA kept in
+// CADir, which defaults to a "ca" directory next to the config file.
+type ProxyConfig struct {
+	Addr      string `json:"addr,omitempty"`
+	Domain    string `json:"domain,omitempty"`
+	HTTPS     bool   `json:"https,omitempty"`
+	HTTPSAddr string `json:"https_addr,omitempty"`
+	CADir     string `json:"ca_dir,omitempty"`
+}
+
+// The DNS server listens on Addr ("127.0.0.1:5300" by default) and answers
+// for the hosts of the stacks in Domain, which defaults to the proxy's. Other
+// queries are forwarded to Forward, such as "8.8.8.8", if it's set.
+type DNSConfig struct {
+	Addr    string `json:"addr,omitempty"`
+	Domain  string `json:"domain,omitempty"`
+	Forward string `json:"forward,omitempty"`
+}
+
+// The in-memory output buffer of a process holds at most Lines messages and
+// at most Bytes bytes. Either limit can be left out (zero) to disable it.
+type LogBufferConfig struct {
+	Lines int `json:"lines,omitempty"`
+	Bytes int `json:"bytes,omitempty"`
+}
+
+// The output of every process is persisted as JSON lines under Dir, which
+// defaults to a "logs" directory next to the config file. A file is rotated
+// once it reaches MaxSize bytes, and only the previous file is kept.
+type LogStoreConfig struct {
+	Disabled bool   `json:"disabled,omitempty"`
+	Dir      string `json:"dir,omitempty"`
+	MaxSize  int64  `json:"max_size,omitempty"`
+}
+
+// A sink receives the output of every process matching Descriptors (all
+// processes when empty). Which of the other fields apply depends on Type:
+//
+//   syslog: Network ("unix", "udp" or "tcp") and Address
+//   file:   Path
+//   tcp:    Address
+//   http:   URL
+type SinkConfig struct {
+	Type        string   `json:"type"`
+	Network     string   `json:"network,omitempty"`
+	Address     string   `json:"address,omitempty"`
+	Path        string   `json:"path,omitempty"`
+	URL         string   `json:"url,omitempty"`
+	Descriptors []string `json:"descriptors,omitempty"`
+}
+
+// logBufferLimits picks the most specific buffer settings, falling back to
+// the default line limit when none are given.
+func logBufferLimits(configs ...*LogBufferConfig) (lines int, bytes int) {
+	for _, c := range configs {
+		if c != nil && (c.Lines > 0 || c.Bytes > 0) {
+			return c.Lines, c.Bytes
+		}
+	}
+	return defaultLogBufferLines, 0
 }
 
 func LoadConfigFromFile(filename string) (*Config, error) {
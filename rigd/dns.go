@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDNSAddr    = "127.0.0.1:5300"
+	dnsForwardTimeout = 2 * time.Second
+
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+	dnsTypeANY  = 255
+	dnsClassIN  = 1
+
+	dnsRcodeFormErr  = 1
+	dnsRcodeServFail = 2
+	dnsRcodeNXDomain = 3
+	dnsRcodeNotImp   = 4
+)
+
+// A DNSServer answers A and AAAA queries for the hosts of the stacks in the
+// development domain, such as api.acme.test, with the loopback addresses.
+// Other names in the domain don't exist, and names outside of it are
+// forwarded to another server if there's one, so it can be the only server
+// of a machine. It only speaks UDP.
+type DNSServer struct {
+	sync.RWMutex
+	config  DNSConfig
+	Domain  string
+	Addr    string
+	Forward string
+	hosts   map[string]bool
+	conn    net.PacketConn
+}
+
+func NewDNSServer(config *DNSConfig, defaultDomain string) *DNSServer {
+	d := &DNSServer{
+		config:  *config,
+		Domain:  strings.Trim(strings.ToLower(config.Domain), "."),
+		Addr:    config.Addr,
+		Forward: config.Forward,
+		hosts:   make(map[string]bool),
+	}
+	if d.Domain == "" {
+		d.Domain = defaultDomain
+	}
+	if d.Addr == "" {
+		d.Addr = defaultDNSAddr
+	}
+	if d.Forward != "" {
+		if _, _, err := net.SplitHostPort(d.Forward); err != nil {
+			d.Forward = net.JoinHostPort(d.Forward, "53")
+		}
+	}
+	return d
+}
+
+// SetHosts generates the table of hosts from the stacks: each service is
+// <service>.<stack>.<domain>, and each process <process>.<service>.<stack>.<domain>.
+func (d *DNSServer) SetHosts(stacks map[string]*Stack) {
+	hosts := make(map[string]bool)
+	for _, s := range stacks {
+		for _, svc := range s.Services {
+			host := strings.ToLower(fmt.Sprintf("%s.%s.%s", svc.Name, s.Name, d.Domain))
+			hosts[host] = true
+			for _, p := range svc.Processes {
+				hosts[strings.ToLower(p.Name)+"."+host] = true
+			}
+		}
+	}
+
+	d.Lock()
+	d.hosts = hosts
+	d.Unlock()
+}
+
+func (d *DNSServer) Hosts() []string {
+	d.RLock()
+	defer d.RUnlock()
+	hosts := make([]string, 0, len(d.hosts))
+	for host := range d.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+func (d *DNSServer) Start() error {
+	conn, err := net.ListenPacket("udp", d.Addr)
+	if err != nil {
+		return err
+	}
+	d.conn = conn
+	log.Printf("[D] Answering DNS queries for *.%s on %s\n", d.Domain, conn.LocalAddr())
+	go d.serve()
+	return nil
+}
+
+func (d *DNSServer) Close() {
+	if d.conn != nil {
+		d.conn.Close()
+	}
+}
+
+func (d *DNSServer) serve() {
+	buf := make([]byte, 4096)
+	for {
+		n, addr, err := d.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		query := append([]byte{}, buf[:n]...)
+		go func() {
+			resp, forward := d.Answer(query)
+			if forward {
+				var err error
+				if resp, err = d.forward(query); err != nil {
+					log.Printf("[D] Error forwarding a query to %s: %v\n", d.Forward, err)
+					resp = dnsResponse(query, dnsRcodeServFail, nil)
+				}
+			}
+			if resp != nil {
+				d.conn.WriteTo(resp, addr)
+			}
+		}()
+	}
+}
+
+func (d *DNSServer) forward(query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", d.Forward, dnsForwardTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dnsForwardTimeout))
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// Answer returns the response to a query, or whether to forward it. Queries
+// which can't be answered at all are dropped, with a nil response.
+func (d *DNSServer) Answer(query []byte) ([]byte, bool) {
+	if len(query) < 12 {
+		return nil, false
+	}
+	flags := binary.BigEndian.Uint16(query[2:4])
+	if flags&0x8000 != 0 {
+		// A response
+		return nil, false
+	}
+	if opcode := (flags >> 11) & 0xf; opcode != 0 {
+		return dnsResponse(query[:12], dnsRcodeNotImp, nil), false
+	}
+	if binary.BigEndian.Uint16(query[4:6]) != 1 {
+		return dnsResponse(query[:12], dnsRcodeFormErr, nil), false
+	}
+	name, length, err := parseDNSName(query[12:])
+	if err != nil || len(query) < 12+length+4 {
+		return dnsResponse(query[:12], dnsRcodeFormErr, nil), false
+	}
+	qtype := binary.BigEndian.Uint16(query[12+length:])
+	qclass := binary.BigEndian.Uint16(query[12+length+2:])
+	question := query[:12+length+4]
+
+	name = strings.ToLower(name)
+	if name != d.Domain && !strings.HasSuffix(name, "."+d.Domain) {
+		if d.Forward != "" {
+			return nil, true
+		}
+		return dnsResponse(question, dnsRcodeNXDomain, nil), false
+	}
+
+	d.RLock()
+	exists := d.hosts[name]
+	d.RUnlock()
+	if !exists {
+		return dnsResponse(question, dnsRcodeNXDomain, nil), false
+	}
+
+	var answers [][]byte
+	if qclass == dnsClassIN {
+		if qtype == dnsTypeA || qtype == dnsTypeANY {
+			answers = append(answers, dnsAnswer(dnsTypeA, net.IPv4(127, 0, 0, 1).To4()))
+		}
+		if qtype == dnsTypeAAAA || qtype == dnsTypeANY {
+			answers = append(answers, dnsAnswer(dnsTypeAAAA, net.IPv6loopback))
+		}
+	}
+	return dnsResponse(question, 0, answers), false
+}
+
+// parseDNSName parses the name at the start of a question, returning it and
+// its length in bytes.
+func parseDNSName(b []byte) (string, int, error) {
+	var labels []string
+	i := 0
+	for {
+		if i >= len(b) {
+			return "", 0, errors.New("truncated name")
+		}
+		n := int(b[i])
+		i++
+		if n == 0 {
+			break
+		}
+		// Questions don't use compression
+		if n&0xc0 != 0 || i+n > len(b) {
+			return "", 0, errors.New("invalid label")
+		}
+		labels = append(labels, string(b[i:i+n]))
+		i += n
+		if i > 255 {
+			return "", 0, errors.New("name too long")
+		}
+	}
+	return strings.Join(labels, "."), i, nil
+}
+
+// dnsResponse builds a response to the question, an authoritative one
+// unless it failed.
+func dnsResponse(question []byte, rcode int, answers [][]byte) []byte {
+	resp := append([]byte{}, question...)
+	flags := binary.BigEndian.Uint16(question[2:4])
+	flags = 0x8000 | flags&0x7900 | uint16(rcode)
+	if rcode == 0 || rcode == dnsRcodeNXDomain {
+		flags |= 0x0400
+	}
+	binary.BigEndian.PutUint16(resp[2:4], flags)
+
+	qdcount := uint16(0)
+	if len(question) > 12 {
+		qdcount = 1
+	}
+	binary.BigEndian.PutUint16(resp[4:6], qdcount)
+	binary.BigEndian.PutUint16(resp[6:8], uint16(len(answers)))
+	binary.BigEndian.PutUint16(resp[8:10], 0)
+	binary.BigEndian.PutUint16(resp[10:12], 0)
+
+	for _, answer := range answers {
+		resp = append(resp, answer...)
+	}
+	return resp
+}
+
+// dnsAnswer builds a resource record for the name of the question, which is
+// always at offset 12. Answers aren't cached, as the hosts can change.
+func dnsAnswer(rtype uint16, data []byte) []byte {
+	rr := make([]byte, 12, 12+len(data))
+	rr[0], rr[1] = 0xc0, 12
+	binary.BigEndian.PutUint16(rr[2:], rtype)
+	binary.BigEndian.PutUint16(rr[4:], dnsClassIN)
+	binary.BigEndian.PutUint32(rr[6:], 0)
+	binary.BigEndian.PutUint16(rr[10:], uint16(len(data)))
+	return append(rr, data...)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func dnsQuery(name string, qtype uint16) []byte {
+	q := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(name, ".") {
+		q = append(q, byte(len(label)))
+		q = append(q, label...)
+	}
+	q = append(q, 0, 0, 0, 0, dnsClassIN)
+	binary.BigEndian.PutUint16(q[len(q)-4:], qtype)
+	return q
+}
+
+func newDNSTestServer(forward string) *DNSServer {
+	stack := NewStack("acme")
+	svc := &Service{Name: "api", Stack: stack, Config: &ServiceConfig{}, Processes: map[string]*Process{}}
+	svc.Processes["worker"] = NewProcess("worker", "rake jobs:work", svc)
+	stack.Services["api"] = svc
+
+	d := NewDNSServer(&DNSConfig{Addr: "127.0.0.1:0", Forward: forward}, "test")
+	d.SetHosts(map[string]*Stack{"acme": stack})
+	return d
+}
+
+func Test_DNSAnswer(t *testing.T) {
+	d := newDNSTestServer("")
+
+	if hosts := d.Hosts(); len(hosts) != 2 || hosts[0] != "api.acme.test" || hosts[1] != "worker.api.acme.test" {
+		t.Errorf("Expected the hosts of the services and processes, got %v", hosts)
+	}
+
+	resp, forward := d.Answer(dnsQuery("API.acme.test", dnsTypeA))
+	if forward {
+		t.Fatalf("Expected the query not to be forwarded")
+	}
+	if resp[0] != 0x12 || resp[1] != 0x34 {
+		t.Errorf("Expected the query's id")
+	}
+	if flags := binary.BigEndian.Uint16(resp[2:4]); flags&0x8000 == 0 || flags&0xf != 0 {
+		t.Errorf("Expected a successful response, got flags %x", flags)
+	}
+	if ancount := binary.BigEndian.Uint16(resp[6:8]); ancount != 1 {
+		t.Fatalf("Expected 1 answer, got %d", ancount)
+	}
+	if ip := net.IP(resp[len(resp)-4:]); !ip.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("Expected 127.0.0.1, got %v", ip)
+	}
+
+	resp, _ = d.Answer(dnsQuery("nope.acme.test", dnsTypeA))
+	if rcode := resp[3] & 0xf; rcode != dnsRcodeNXDomain {
+		t.Errorf("Expected NXDOMAIN for an unknown host, got %d", rcode)
+	}
+	resp, _ = d.Answer(dnsQuery("example.com", dnsTypeA))
+	if rcode := resp[3] & 0xf; rcode != dnsRcodeNXDomain {
+		t.Errorf("Expected NXDOMAIN outside of the domain without forwarding, got %d", rcode)
+	}
+	if _, forward := newDNSTestServer("8.8.8.8").Answer(dnsQuery("example.com", dnsTypeA)); !forward {
+		t.Errorf("Expected queries outside of the domain to be forwarded")
+	}
+
+	resp, _ = d.Answer(dnsQuery("api.acme.test", 16))
+	if rcode, ancount := resp[3]&0xf, binary.BigEndian.Uint16(resp[6:8]); rcode != 0 || ancount != 0 {
+		t.Errorf("Expected no answer for a TXT query, got rcode %d and %d answers", rcode, ancount)
+	}
+
+	if resp, _ := d.Answer([]byte{1, 2, 3}); resp != nil {
+		t.Errorf("Expected a truncated query to be dropped")
+	}
+	resp, _ = d.Answer(dnsQuery("api.acme.test", dnsTypeA)[:20])
+	if rcode := resp[3] & 0xf; rcode != dnsRcodeFormErr {
+		t.Errorf("Expected FORMERR for a truncated question, got %d", rcode)
+	}
+}
+
+func Test_DNSServer(t *testing.T) {
+	upstream := NewDNSServer(&DNSConfig{Addr: "127.0.0.1:0", Domain: "example"}, "")
+	upstream.hosts["www.example"] = true
+	if err := upstream.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+
+	d := newDNSTestServer(upstream.conn.LocalAddr().String())
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "udp", d.conn.LocalAddr().String())
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, host := range []string{"worker.api.acme.test", "www.example"} {
+		addrs, err := r.LookupIPAddr(ctx, host)
+		if err != nil {
+			t.Errorf("Expected %s to resolve, got %v", host, err)
+			continue
+		}
+		if len(addrs) != 2 {
+			t.Errorf("Expected 127.0.0.1 and ::1 for %s, got %v", host, addrs)
+		}
+	}
+
+	_, err := r.LookupIPAddr(ctx, "nope.acme.test")
+	if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+		t.Errorf("Expected nope.acme.test not to be found, got %v", err)
+	}
+}
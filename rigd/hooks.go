@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gocardless/rig/utils"
+	"log"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	HookPreStart  = "pre_start"
+	HookPostStart = "post_start"
+	HookPreStop   = "pre_stop"
+	HookPostStop  = "post_stop"
+)
+
+const defaultHookTimeout = 5 * time.Minute
+
+// mergeHooks gives each hook of a process its own command, or the service's
+// when it has none.
+func mergeHooks(process, service *HooksConfig) *HooksConfig {
+	merged := &HooksConfig{}
+	for _, c := range []*HooksConfig{service, process} {
+		if c == nil {
+			continue
+		}
+		if c.PreStart != "" {
+			merged.PreStart = c.PreStart
+		}
+		if c.PostStart != "" {
+			merged.PostStart = c.PostStart
+		}
+		if c.PreStop != "" {
+			merged.PreStop = c.PreStop
+		}
+		if c.PostStop != "" {
+			merged.PostStop = c.PostStop
+		}
+		if c.Timeout != "" {
+			merged.Timeout = c.Timeout
+		}
+	}
+	return merged
+}
+
+// hookTimeout returns how long hooks may run
+func hookTimeout(hooks *HooksConfig) (time.Duration, error) {
+	if hooks.Timeout == "" {
+		return defaultHookTimeout, nil
+	}
+	d, err := utils.ParseDuration(hooks.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hooks timeout '%s': %v", hooks.Timeout, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid hooks timeout '%s': must be positive", hooks.Timeout)
+	}
+	return d, nil
+}
+
+// runHook runs a hook command like the process itself, in the service's dir
+// and with the process's environment. Its output is published as the
+// process's, with the name of the hook as its stream. It's killed, with its
+// children, if it runs for longer than the hooks timeout, or if the start
+// it's a pre_start hook of is aborted.
+func (p *Process) runHook(name, command string) error {
+	if command == "" {
+		return nil
+	}
+
+	log.Printf("[P] Running %s hook of %s\n", name, p.Sqd())
+	cmd := p.shellCommand(command)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	pgid := cmd.Process.Pid
+
+	timeout := p.hookTimeout
+	if timeout == 0 {
+		timeout = defaultHookTimeout
+	}
+	var timedOut int32
+	timer := time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&timedOut, 1)
+		log.Printf("[P] %s hook of %s timed out after %v, killing it\n", name, p.Sqd(), timeout)
+		syscall.Kill(-pgid, syscall.SIGKILL)
+	})
+	defer timer.Stop()
+
+	if name == HookPreStart {
+		p.mu.Lock()
+		p.preStartHook = pgid
+		// Stopped while the hook was being spawned
+		if p.abortingStart {
+			syscall.Kill(-pgid, syscall.SIGKILL)
+		}
+		p.mu.Unlock()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go p.logStream(stdout, name, &wg)
+	go p.logStream(stderr, name, &wg)
+	wg.Wait()
+
+	// Before it's reaped, so its pgid can't be reused while it's set
+	if name == HookPreStart {
+		p.mu.Lock()
+		p.preStartHook = 0
+		p.mu.Unlock()
+	}
+
+	err = cmd.Wait()
+	if atomic.LoadInt32(&timedOut) == 1 {
+		return fmt.Errorf("timed out after %v", timeout)
+	}
+	return err
+}
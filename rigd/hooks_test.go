@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_MergeHooks(t *testing.T) {
+	service := &HooksConfig{PreStart: "bundle install", PostStop: "rm -f tmp/pids/server.pid"}
+	process := &HooksConfig{PreStart: "yarn install"}
+
+	hooks := mergeHooks(process, service)
+	if hooks.PreStart != "yarn install" {
+		t.Errorf("Expected the process's pre_start hook, got %s", hooks.PreStart)
+	}
+	if hooks.PostStop != "rm -f tmp/pids/server.pid" {
+		t.Errorf("Expected the service's post_stop hook, got %s", hooks.PostStop)
+	}
+
+	if hooks := mergeHooks(nil, nil); hooks.PreStart != "" {
+		t.Errorf("Expected no hooks, got %+v", hooks)
+	}
+}
+
+func Test_FailingPreStartHookAbortsStart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stack := NewStack("acme")
+	svc := &Service{Name: "api", Dir: dir, Stack: stack, Config: &ServiceConfig{}, Processes: map[string]*Process{}}
+	p := NewProcess("web", "echo started", svc)
+	p.hooks = &HooksConfig{PreStart: "echo installing; exit 3"}
+
+	err = p.Start()
+	if err == nil || !strings.Contains(err.Error(), "pre_start hook failed") {
+		t.Errorf("Expected the pre_start hook to fail, got %v", err)
+	}
+	if p.state().process != nil {
+		t.Errorf("Expected the process not to be started")
+	}
+
+	found := false
+	for _, msg := range p.History(100, nil) {
+		if msg.Content == "installing" && msg.Stream == HookPreStart {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected the hook's output in the process's history")
+	}
+}
+
+func Test_StartDuringPreStartHook(t *testing.T) {
+	svc := &Service{Name: "api", Stack: NewStack("acme"), Config: &ServiceConfig{}}
+	p := NewProcess("web", "sleep 30", svc)
+	// Stopping kills the hook rather than waiting for it
+	p.hooks = &HooksConfig{PreStart: "sleep 30"}
+
+	first := make(chan error)
+	go func() {
+		first <- p.Start()
+	}()
+	for i := 0; i < 200 && p.status() != Starting; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if p.status() != Starting {
+		t.Fatalf("Expected the process to be starting")
+	}
+
+	if err := p.Start(); err == nil {
+		t.Errorf("Expected an error starting the process twice")
+	}
+	if err := p.Restart(); err == nil {
+		t.Errorf("Expected an error restarting a starting process")
+	}
+	if err := p.Stop(); err != nil {
+		t.Errorf("Expected stopping a starting process not to fail, got %v", err)
+	}
+
+	select {
+	case err := <-first:
+		if err == nil || !strings.Contains(err.Error(), "stopped while starting") {
+			t.Errorf("Expected the start to be aborted, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Expected the start to be aborted")
+	}
+	if st := p.state(); st.process != nil || st.status != Stopped {
+		t.Errorf("Expected the process not to run, got %+v", st)
+	}
+}
+
+func Test_HookTimeout(t *testing.T) {
+	svc := &Service{Name: "api", Stack: NewStack("acme"), Config: &ServiceConfig{}}
+	p := NewProcess("web", "echo started", svc)
+	p.hooks = &HooksConfig{PreStart: "sleep 30 & sleep 30"}
+	p.hookTimeout = 200 * time.Millisecond
+
+	started := time.Now()
+	err := p.Start()
+	if err == nil || !strings.Contains(err.Error(), "timed out after 200ms") {
+		t.Errorf("Expected the pre_start hook to time out, got %v", err)
+	}
+	if time.Since(started) > 10*time.Second {
+		t.Errorf("Expected the hook and its children to be killed")
+	}
+	if st := p.state(); st.process != nil || st.status != Stopped {
+		t.Errorf("Expected the process not to run, got %+v", st)
+	}
+}
+
+func Test_HookTimeoutConfig(t *testing.T) {
+	if d, err := hookTimeout(&HooksConfig{}); err != nil || d != defaultHookTimeout {
+		t.Errorf("Expected the default timeout, got %v (%v)", d, err)
+	}
+	if d, err := hookTimeout(mergeHooks(nil, &HooksConfig{Timeout: "2m"})); err != nil || d != 2*time.Minute {
+		t.Errorf("Expected the service's timeout, got %v (%v)", d, err)
+	}
+	for _, timeout := range []string{"soon", "0s"} {
+		if _, err := hookTimeout(&HooksConfig{Timeout: timeout}); err == nil {
+			t.Errorf("Expected an error for '%s'", timeout)
+		}
+	}
+}
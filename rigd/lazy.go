@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	LazyIdle   = "idle"
+	LazyWaking = "waking"
+	LazyActive = "active"
+
+	defaultLazyIdleTimeout  = 15 * time.Minute
+	defaultLazyStartTimeout = time.Minute
+)
+
+// Compiled lazy settings of a process, see LazyConfig
+type LazyRules struct {
+	IdleTimeout  time.Duration
+	StartTimeout time.Duration
+}
+
+func NewLazyRules(config *LazyConfig) (*LazyRules, error) {
+	rules := &LazyRules{
+		IdleTimeout:  defaultLazyIdleTimeout,
+		StartTimeout: defaultLazyStartTimeout,
+	}
+	if config.IdleTimeout != "" {
+		d, err := time.ParseDuration(config.IdleTimeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid idle timeout '%s'", config.IdleTimeout)
+		}
+		rules.IdleTimeout = d
+	}
+	if config.StartTimeout != "" {
+		d, err := time.ParseDuration(config.StartTimeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid start timeout '%s'", config.StartTimeout)
+		}
+		rules.StartTimeout = d
+	}
+	return rules, nil
+}
+
+// A lazyListener listens on the port of a process while it's stopped, and
+// starts it on the first connection. The process gets another port, and
+// connections are proxied to it once it accepts them. When it's had no
+// connections for the idle timeout, it's stopped again.
+type lazyListener struct {
+	sync.Mutex
+	process  *Process
+	listener net.Listener
+	state    string
+	wakeup   *lazyWakeup
+	// Closed when the current run of the process exits
+	exited   chan bool
+	port     int
+	conns    int
+	lastUsed time.Time
+	stopCh   chan bool
+}
+
+// A lazyWakeup is done once the process accepts connections, or failed to
+type lazyWakeup struct {
+	done chan bool
+	err  error
+}
+
+func (p *Process) startLazy() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lazyListener != nil {
+		return fmt.Errorf("Process '%s' is already listening", p.Sqd())
+	}
+
+	if err := p.checkPort(p.Port(), false, nil); err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", p.Port()))
+	if err != nil {
+		return fmt.Errorf("Error listening for %s: %v", p.Sqd(), err)
+	}
+	s := &lazyListener{process: p, listener: l, state: LazyIdle, stopCh: make(chan bool)}
+	p.lazyListener = s
+	go s.accept()
+	go s.stopWhenIdle()
+	log.Printf("[P] Listening on port %d for %s, which starts on the first connection\n", p.Port(), p.Sqd())
+	return nil
+}
+
+// stopLazy stops listening on the port of the process, without stopping it.
+// A run it was waking up which hasn't spawned the command yet doesn't. It
+// returns whether it was listening.
+func (p *Process) stopLazy() bool {
+	p.mu.Lock()
+	s := p.lazyListener
+	if s == nil {
+		p.mu.Unlock()
+		return false
+	}
+	p.lazyListener = nil
+	close(s.stopCh)
+	p.mu.Unlock()
+
+	s.listener.Close()
+	log.Printf("[P] Stopped listening on port %d for %s\n", p.Port(), p.Sqd())
+	return true
+}
+
+func (p *Process) listening() *lazyListener {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lazyListener
+}
+
+// LazyState is idle, waking or active for a lazy process which is listening,
+// and empty otherwise.
+func (p *Process) LazyState() string {
+	s := p.listening()
+	if s == nil {
+		return ""
+	}
+	s.Lock()
+	defer s.Unlock()
+	return s.state
+}
+
+// listenPort is the port the process itself listens on
+func (p *Process) listenPort() int {
+	if s := p.listening(); s != nil {
+		s.Lock()
+		defer s.Unlock()
+		if s.port != 0 {
+			return s.port
+		}
+	}
+	return p.Port()
+}
+
+func (s *lazyListener) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *lazyListener) handle(conn net.Conn) {
+	defer conn.Close()
+
+	s.Lock()
+	s.conns++
+	s.Unlock()
+	defer func() {
+		s.Lock()
+		s.conns--
+		s.lastUsed = time.Now()
+		s.Unlock()
+	}()
+
+	w := s.wake()
+	select {
+	case <-w.done:
+	case <-s.stopCh:
+		return
+	}
+	if w.err != nil {
+		return
+	}
+
+	s.Lock()
+	port := s.port
+	s.Unlock()
+	backend, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		log.Printf("[P] Error connecting to %s: %v\n", s.process.Sqd(), err)
+		return
+	}
+	defer backend.Close()
+
+	done := make(chan bool, 2)
+	go copyConn(backend, conn, done)
+	go copyConn(conn, backend, done)
+	<-done
+	<-done
+}
+
+// copyConn copies until src is closed, then closes dst for writing so the
+// other side sees the end too.
+func copyConn(dst, src net.Conn, done chan bool) {
+	io.Copy(dst, src)
+	if c, ok := dst.(*net.TCPConn); ok {
+		c.CloseWrite()
+	} else {
+		dst.Close()
+	}
+	done <- true
+}
+
+// wake starts the process if it's idle, and returns the wakeup to wait for
+func (s *lazyListener) wake() *lazyWakeup {
+	s.Lock()
+	defer s.Unlock()
+	if s.state == LazyIdle {
+		s.state = LazyWaking
+		s.wakeup = &lazyWakeup{done: make(chan bool)}
+		go s.boot(s.wakeup, s.exited)
+	}
+	return s.wakeup
+}
+
+func (s *lazyListener) boot(w *lazyWakeup, previous chan bool) {
+	p := s.process
+	// The previous run can still be stopping
+	if previous != nil {
+		<-previous
+	}
+
+	port, err := freePort()
+	if err == nil {
+		exited := make(chan bool)
+		s.Lock()
+		s.port = port
+		s.exited = exited
+		s.Unlock()
+
+		log.Printf("[P] Waking %s up\n", p.Sqd())
+		go func() {
+			if err := p.runFor(s); err != nil {
+				log.Printf("[P] %v\n", err)
+			}
+			close(exited)
+			// It's idle again if it exits by itself
+			s.Lock()
+			if s.exited == exited && s.state == LazyActive {
+				s.state = LazyIdle
+			}
+			s.Unlock()
+		}()
+		err = s.waitForPort(port, exited)
+	}
+
+	s.Lock()
+	if err != nil {
+		log.Printf("[P] Error waking %s up: %v\n", p.Sqd(), err)
+		s.state = LazyIdle
+	} else {
+		s.state = LazyActive
+		s.lastUsed = time.Now()
+	}
+	w.err = err
+	s.Unlock()
+	close(w.done)
+}
+
+func (s *lazyListener) waitForPort(port int, exited chan bool) error {
+	p := s.process
+	timeout := time.After(p.lazy.StartTimeout)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-exited:
+			return fmt.Errorf("%s exited before accepting connections", p.Sqd())
+		case <-timeout:
+			// It can still be in its pre_start hook
+			if !p.abortStart() {
+				p.terminate()
+			}
+			return fmt.Errorf("%s didn't accept connections on port %d within %v", p.Sqd(), port, p.lazy.StartTimeout)
+		case <-s.stopCh:
+			return fmt.Errorf("%s isn't listening anymore", p.Sqd())
+		}
+
+		conn, err := net.DialTimeout("tcp", fmt.Sprintf("localhost:%d", port), time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+	}
+}
+
+func (s *lazyListener) stopWhenIdle() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.stopCh:
+			return
+		}
+
+		s.Lock()
+		idle := s.state == LazyActive && s.conns == 0 && time.Since(s.lastUsed) >= s.process.lazy.IdleTimeout
+		if idle {
+			s.state = LazyIdle
+		}
+		s.Unlock()
+
+		if idle {
+			log.Printf("[P] %s has had no connections for %v, stopping it\n", s.process.Sqd(), s.process.lazy.IdleTimeout)
+			s.process.terminate()
+		}
+	}
+}
+
+// freePort finds a port nothing listens on
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// carryOverLazy moves listening over to the new instances of lazy processes
+// after a reload. Running ones are stopped, and start again on the next
+// connection.
+func carryOverLazy(oldStacks, stacks map[string]*Stack) {
+	for _, s := range oldStacks {
+		for _, svc := range s.Services {
+			for _, old := range svc.Processes {
+				if !old.stopLazy() {
+					continue
+				}
+				if !old.abortStart() && old.status() == Running {
+					old.terminate()
+				}
+				p, err := getProcess(stacks, old.descriptor())
+				if err == nil && p.lazy != nil {
+					if err := p.startLazy(); err != nil {
+						log.Printf("[P] %v\n", err)
+					}
+				}
+			}
+		}
+	}
+}
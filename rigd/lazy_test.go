@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_NewLazyRules(t *testing.T) {
+	rules, err := NewLazyRules(&LazyConfig{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if rules.IdleTimeout != defaultLazyIdleTimeout || rules.StartTimeout != defaultLazyStartTimeout {
+		t.Errorf("Expected the default timeouts, got %v", rules)
+	}
+
+	for _, config := range []*LazyConfig{{IdleTimeout: "soon"}, {IdleTimeout: "0s"}, {StartTimeout: "-1s"}} {
+		if _, err := NewLazyRules(config); err == nil {
+			t.Errorf("Expected an error for %v", config)
+		}
+	}
+}
+
+func Test_ProcessLazy(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is needed to serve HTTP")
+	}
+	port, err := freePort()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	svc := &Service{Name: "api", Stack: NewStack("acme"), Config: &ServiceConfig{Port: port}}
+	p := NewProcess("web", "exec python3 -m http.server $PORT --bind 127.0.0.1", svc)
+	p.lazy = &LazyRules{IdleTimeout: time.Second, StartTimeout: 30 * time.Second}
+
+	if err := p.Start(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer p.Stop()
+	if state := p.LazyState(); state != LazyIdle {
+		t.Errorf("Expected the process to be idle, got '%s'", state)
+	}
+	if p.status() != Stopped {
+		t.Errorf("Expected the process not to run before a connection")
+	}
+
+	res, err := http.Get("http://localhost:" + strconv.Itoa(port) + "/")
+	if err != nil {
+		t.Fatalf("Expected the request to wake the process up, got %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", res.StatusCode)
+	}
+	if state := p.LazyState(); state != LazyActive {
+		t.Errorf("Expected the process to be active, got '%s'", state)
+	}
+	if p.listenPort() == port {
+		t.Errorf("Expected the process to listen on another port than rigd")
+	}
+
+	for i := 0; i < 100 && p.status() == Running; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if p.status() == Running {
+		t.Errorf("Expected the process to stop once idle")
+	}
+	if state := p.LazyState(); state != LazyIdle {
+		t.Errorf("Expected the process to be idle again, got '%s'", state)
+	}
+}
+
+func Test_StopLazyWhileWaking(t *testing.T) {
+	port, err := freePort()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	svc := &Service{Name: "api", Stack: NewStack("acme"), Config: &ServiceConfig{Port: port}}
+	p := NewProcess("web", "sleep 10", svc)
+	p.hooks = &HooksConfig{PreStart: "sleep 1"}
+	p.lazy = &LazyRules{IdleTimeout: time.Minute, StartTimeout: 30 * time.Second}
+
+	if err := p.Start(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	go http.Get("http://localhost:" + strconv.Itoa(port) + "/")
+	for i := 0; i < 50 && p.LazyState() != LazyWaking; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if state := p.LazyState(); state != LazyWaking {
+		t.Fatalf("Expected the process to be waking up, got '%s'", state)
+	}
+
+	if err := p.Stop(); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	// Once its pre_start hook is done
+	for i := 0; i < 100 && p.status() == Starting; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if st := p.state(); st.status != Stopped || st.process != nil {
+		t.Errorf("Expected the process not to start once stopped, got %+v", st)
+		p.terminate()
+	}
+}
+
+func Test_ServiceLazy(t *testing.T) {
+	svc := &Service{Name: "api", Stack: NewStack("acme"), Processes: map[string]*Process{}, Tasks: map[string]*Task{}}
+	svc.Processes["web"] = NewProcess("web", "rails server", svc)
+	svc.Processes["worker"] = NewProcess("worker", "sidekiq", svc)
+
+	err := svc.Configure(&Config{}, &ServiceConfig{Port: 3000, Lazy: &LazyConfig{}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if svc.Processes["web"].lazy == nil {
+		t.Errorf("Expected the web process to be lazy")
+	}
+	if svc.Processes["worker"].lazy != nil {
+		t.Errorf("Expected the worker without a port not to be lazy")
+	}
+
+	svc.Processes["worker"].Config = &ProcessConfig{Lazy: &LazyConfig{}}
+	if err := svc.Configure(&Config{}, &ServiceConfig{Port: 3000}); err == nil {
+		t.Errorf("Expected an error for a lazy process without a port")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gocardless/rig/utils"
+	"strings"
+	"time"
+)
+
+// Compiled resource limits of a process. NoFile, AddressSpace (in bytes) and
+// CPUTime are rlimits, set with ulimit by the shell running the process, so
+// they apply on every platform. Memory (in bytes), CPU (in cores) and Pids are
+// enforced by a cgroup v2, on Linux, when rigd has been delegated one.
+type ProcessLimits struct {
+	NoFile       uint64
+	AddressSpace uint64
+	CPUTime      time.Duration
+	Memory       uint64
+	CPU          float64
+	Pids         int
+}
+
+func NewProcessLimits(config *LimitsConfig) (*ProcessLimits, error) {
+	limits := &ProcessLimits{
+		NoFile: config.NoFile,
+		CPU:    config.CPU,
+		Pids:   config.Pids,
+	}
+
+	if config.AddressSpace != "" {
+		n, err := utils.ParseBytes(config.AddressSpace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address_space limit: %v", err)
+		}
+		limits.AddressSpace = n
+	}
+
+	if config.CPUTime != "" {
+		d, err := utils.ParseDuration(config.CPUTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu_time limit '%s': %v", config.CPUTime, err)
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("invalid cpu_time limit '%s': must be at least 1s", config.CPUTime)
+		}
+		limits.CPUTime = d
+	}
+
+	if config.Memory != "" {
+		n, err := utils.ParseBytes(config.Memory)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory limit: %v", err)
+		}
+		limits.Memory = n
+	}
+
+	if config.CPU < 0 || config.Pids < 0 {
+		return nil, fmt.Errorf("cpu and pids limits can't be negative")
+	}
+
+	return limits, nil
+}
+
+// wrapCommand returns the command preceded by the ulimit commands. If one
+// fails, the command isn't run. The command is grouped, so the limits apply
+// to all of a compound command like "a || b", and ends with a newline in
+// case it ends with a comment.
+func (l *ProcessLimits) wrapCommand(command string) string {
+	if l == nil {
+		return command
+	}
+
+	var cmds []string
+	if l.NoFile > 0 {
+		cmds = append(cmds, fmt.Sprintf("ulimit -n %d", l.NoFile))
+	}
+	if l.AddressSpace > 0 {
+		// In kilobytes
+		cmds = append(cmds, fmt.Sprintf("ulimit -v %d", (l.AddressSpace+1023)/1024))
+	}
+	if l.CPUTime > 0 {
+		cmds = append(cmds, fmt.Sprintf("ulimit -t %d", int(l.CPUTime.Seconds())))
+	}
+
+	if len(cmds) == 0 {
+		return command
+	}
+	return strings.Join(cmds, " && ") + " && {\n" + command + "\n}"
+}
+
+func (l *ProcessLimits) needsCgroup() bool {
+	return l != nil && (l.Memory > 0 || l.CPU > 0 || l.Pids > 0)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func Test_NewProcessLimits(t *testing.T) {
+	limits, err := NewProcessLimits(&LimitsConfig{
+		NoFile:       1024,
+		AddressSpace: "4G",
+		CPUTime:      "10m",
+		Memory:       "512M",
+		CPU:          1.5,
+		Pids:         100,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if limits.AddressSpace != 4<<30 {
+		t.Errorf("Expected AddressSpace to be 4G, got %d", limits.AddressSpace)
+	}
+	if limits.CPUTime != 10*time.Minute {
+		t.Errorf("Expected CPUTime to be 10m, got %v", limits.CPUTime)
+	}
+	if limits.Memory != 512<<20 {
+		t.Errorf("Expected Memory to be 512M, got %d", limits.Memory)
+	}
+	if !limits.needsCgroup() {
+		t.Errorf("Expected memory, cpu and pids limits to need a cgroup")
+	}
+}
+
+func Test_NewProcessLimitsInvalid(t *testing.T) {
+	for _, config := range []*LimitsConfig{
+		{AddressSpace: "lots"},
+		{CPUTime: "500ms"},
+		{Memory: "1.5G"},
+		{Pids: -1},
+	} {
+		if _, err := NewProcessLimits(config); err == nil {
+			t.Errorf("Expected an error for %+v", config)
+		}
+	}
+}
+
+func Test_WrapCommand(t *testing.T) {
+	limits := &ProcessLimits{NoFile: 256, AddressSpace: 1 << 30, CPUTime: time.Minute}
+	expected := "ulimit -n 256 && ulimit -v 1048576 && ulimit -t 60 && {\nrails server\n}"
+	if command := limits.wrapCommand("rails server"); command != expected {
+		t.Errorf("Expected the command to be %q, got %q", expected, command)
+	}
+
+	var none *ProcessLimits
+	if command := none.wrapCommand("rails server"); command != "rails server" {
+		t.Errorf("Expected the command as it is without limits, got %q", command)
+	}
+	if (&ProcessLimits{Memory: 1 << 20}).wrapCommand("rails server") != "rails server" {
+		t.Errorf("Expected the command as it is with cgroup limits only")
+	}
+}
+
+func Test_WrapCompoundCommand(t *testing.T) {
+	limits := &ProcessLimits{NoFile: 123}
+	command := limits.wrapCommand("false || ulimit -n; ulimit -n # comment")
+
+	out, err := exec.Command("sh", "-c", command).Output()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if string(out) != "123\n123\n" {
+		t.Errorf("Expected every part of the command to run with the limits, got %q", out)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"container/ring"
+	"github.com/gocardless/rig"
+	"sync"
+	"unsafe"
+)
+
+const defaultLogBufferLines = 100
+
+// LogBuffer keeps the most recent output of a process in memory. It is
+// bounded by a number of lines and/or a number of bytes, the oldest messages
+// being dropped first.
+type LogBuffer struct {
+	sync.Mutex
+	messages []rig.ProcessOutputMessage
+	maxLines int
+	maxBytes int
+	size     int
+}
+
+func NewLogBuffer(lines, bytes int) *LogBuffer {
+	return &LogBuffer{maxLines: lines, maxBytes: bytes}
+}
+
+func (b *LogBuffer) Append(msg rig.ProcessOutputMessage) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.messages = append(b.messages, msg)
+	b.size += messageSize(msg)
+	b.evict()
+}
+
+// Resize changes the limits of the buffer, keeping as many of the most recent
+// messages as the new limits allow.
+func (b *LogBuffer) Resize(lines, bytes int) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.maxLines = lines
+	b.maxBytes = bytes
+	b.evict()
+}
+
+func (b *LogBuffer) Limits() (lines int, bytes int) {
+	b.Lock()
+	defer b.Unlock()
+	return b.maxLines, b.maxBytes
+}
+
+// Number of messages currently held
+func (b *LogBuffer) Len() int {
+	b.Lock()
+	defer b.Unlock()
+	return len(b.messages)
+}
+
+// Approximate memory used by the messages currently held, in bytes
+func (b *LogBuffer) Size() int {
+	b.Lock()
+	defer b.Unlock()
+	return b.size
+}
+
+// Ring returns a snapshot of the buffer as a ring pointing at the most recent
+// message, which is what MultiTail expects.
+func (b *LogBuffer) Ring() *ring.Ring {
+	b.Lock()
+	defer b.Unlock()
+
+	if len(b.messages) == 0 {
+		return ring.New(1)
+	}
+
+	r := ring.New(len(b.messages))
+	for i, msg := range b.messages {
+		if i > 0 {
+			r = r.Next()
+		}
+		r.Value = msg
+	}
+	return r
+}
+
+// Must be called with the lock held. The most recent message is always kept,
+// even if it's bigger than the byte limit on its own.
+func (b *LogBuffer) evict() {
+	for len(b.messages) > 1 {
+		overLines := b.maxLines > 0 && len(b.messages) > b.maxLines
+		overBytes := b.maxBytes > 0 && b.size > b.maxBytes
+		if !overLines && !overBytes {
+			break
+		}
+		b.size -= messageSize(b.messages[0])
+		b.messages[0] = rig.ProcessOutputMessage{}
+		b.messages = b.messages[1:]
+	}
+}
+
+func messageSize(msg rig.ProcessOutputMessage) int {
+	return int(unsafe.Sizeof(msg)) + len(msg.Content)
+}
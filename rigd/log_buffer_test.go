@@ -0,0 +1,65 @@
+package main
+
+import (
+	"container/ring"
+	"github.com/gocardless/rig"
+	"testing"
+	"time"
+)
+
+func Test_LogBufferLineLimit(t *testing.T) {
+	buf := NewLogBuffer(2, 0)
+	buf.Append(rig.ProcessOutputMessage{Content: "a", Time: time.Now()})
+	buf.Append(rig.ProcessOutputMessage{Content: "b", Time: time.Now().Add(1)})
+	buf.Append(rig.ProcessOutputMessage{Content: "c", Time: time.Now().Add(2)})
+
+	if buf.Len() != 2 {
+		t.Errorf("Expected buf.Len() to be 2, got %d", buf.Len())
+	}
+	tail := MultiTail([]*ring.Ring{buf.Ring()}, 10)
+	if len(tail) != 2 || tail[0].Content != "b" || tail[1].Content != "c" {
+		t.Errorf("Expected tail to be [b c], got %v", tail)
+	}
+}
+
+func Test_LogBufferByteLimit(t *testing.T) {
+	msg := rig.ProcessOutputMessage{Content: "0123456789"}
+	buf := NewLogBuffer(0, 2*messageSize(msg))
+	for i := 0; i < 5; i++ {
+		buf.Append(msg)
+	}
+
+	if buf.Len() != 2 {
+		t.Errorf("Expected buf.Len() to be 2, got %d", buf.Len())
+	}
+	if buf.Size() != 2*messageSize(msg) {
+		t.Errorf("Expected buf.Size() to be %d, got %d", 2*messageSize(msg), buf.Size())
+	}
+}
+
+func Test_LogBufferResizeKeepsRecentLines(t *testing.T) {
+	buf := NewLogBuffer(3, 0)
+	buf.Append(rig.ProcessOutputMessage{Content: "a", Time: time.Now()})
+	buf.Append(rig.ProcessOutputMessage{Content: "b", Time: time.Now().Add(1)})
+	buf.Append(rig.ProcessOutputMessage{Content: "c", Time: time.Now().Add(2)})
+
+	buf.Resize(1, 0)
+	tail := MultiTail([]*ring.Ring{buf.Ring()}, 10)
+	if len(tail) != 1 || tail[0].Content != "c" {
+		t.Errorf("Expected tail to be [c], got %v", tail)
+	}
+
+	buf.Resize(5, 0)
+	buf.Append(rig.ProcessOutputMessage{Content: "d", Time: time.Now().Add(3)})
+	if buf.Len() != 2 {
+		t.Errorf("Expected buf.Len() to be 2, got %d", buf.Len())
+	}
+}
+
+func Test_EmptyLogBuffer(t *testing.T) {
+	buf := NewLogBuffer(3, 0)
+	tail := MultiTail([]*ring.Ring{buf.Ring()}, 10)
+	if len(tail) != 0 {
+		t.Errorf("Expected len(tail) to be 0, got %d", len(tail))
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"container/ring"
+	"fmt"
+	"github.com/gocardless/rig"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// LogFilter selects the output lines sent to a subscriber. A line is kept if
+// it matches any of the include regexps or substrings (or if there are none),
+// and doesn't match any of the exclude regexps. Substrings are matched
+// case-insensitively. When a minimum level is set, only structured lines of
+// that level or above are kept. With stripANSI set, terminal escape sequences
+// are removed from lines before they're matched and sent.
+type LogFilter struct {
+	include   []*regexp.Regexp
+	exclude   []*regexp.Regexp
+	contains  []string
+	minLevel  int
+	stripANSI bool
+}
+
+// ansiSequence matches CSI sequences (colours, cursor movements...), OSC
+// sequences (window titles...) and other escapes, such as charset changes.
+var ansiSequence = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[ -/]*[0-~]`)
+
+func StripANSI(s string) string {
+	if !strings.Contains(s, "\x1b") {
+		return s
+	}
+	return ansiSequence.ReplaceAllString(s, "")
+}
+
+// NewLogFilter builds a filter from the "include", "exclude" and "contains"
+// query parameters, each of which can be repeated, and the "level" and
+// "strip_ansi" parameters.
+func NewLogFilter(values url.Values) (*LogFilter, error) {
+	f := &LogFilter{minLevel: -1}
+
+	if strip := values.Get("strip_ansi"); strip != "" {
+		f.stripANSI = strip == "true" || strip == "1"
+	}
+
+	if level := values.Get("level"); level != "" {
+		if !isLevel(level) {
+			return nil, fmt.Errorf("Bad parameter: unknown level '%s'", level)
+		}
+		f.minLevel = levelRank(level)
+	}
+
+	for _, expr := range values["include"] {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("Bad parameter: invalid include pattern '%s': %v", expr, err)
+		}
+		f.include = append(f.include, re)
+	}
+
+	for _, expr := range values["exclude"] {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("Bad parameter: invalid exclude pattern '%s': %v", expr, err)
+		}
+		f.exclude = append(f.exclude, re)
+	}
+
+	for _, str := range values["contains"] {
+		f.contains = append(f.contains, strings.ToLower(str))
+	}
+
+	return f, nil
+}
+
+// A nil filter matches everything
+func (f *LogFilter) Match(msg rig.ProcessOutputMessage) bool {
+	if f == nil {
+		return true
+	}
+
+	if f.minLevel >= 0 && (msg.Level == "" || levelRank(msg.Level) < f.minLevel) {
+		return false
+	}
+
+	for _, re := range f.exclude {
+		if re.MatchString(msg.Content) {
+			return false
+		}
+	}
+
+	if len(f.include) == 0 && len(f.contains) == 0 {
+		return true
+	}
+
+	for _, re := range f.include {
+		if re.MatchString(msg.Content) {
+			return true
+		}
+	}
+
+	if len(f.contains) > 0 {
+		content := strings.ToLower(msg.Content)
+		for _, str := range f.contains {
+			if strings.Contains(content, str) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Apply returns the message as the subscriber receives it, and whether it
+// matches the filter.
+func (f *LogFilter) Apply(msg rig.ProcessOutputMessage) (rig.ProcessOutputMessage, bool) {
+	if f != nil && f.stripANSI {
+		msg.Content = StripANSI(msg.Content)
+	}
+	return msg, f.Match(msg)
+}
+
+// Tail returns the last num messages of the buffers which match the filter,
+// in chronological order.
+func (f *LogFilter) Tail(buffers []*ring.Ring, num int) []*rig.ProcessOutputMessage {
+	if f == nil {
+		return MultiTail(buffers, num)
+	}
+
+	total := 0
+	for _, buf := range buffers {
+		total += buf.Len()
+	}
+
+	tail := []*rig.ProcessOutputMessage{}
+	for _, msg := range MultiTail(buffers, total) {
+		if m, ok := f.Apply(*msg); ok {
+			tail = append(tail, &m)
+		}
+	}
+
+	if len(tail) > num {
+		tail = tail[len(tail)-num:]
+	}
+	return tail
+}
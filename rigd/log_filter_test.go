@@ -0,0 +1,119 @@
+package main
+
+import (
+	"container/ring"
+	"github.com/gocardless/rig"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type logFilterTest struct {
+	query   string
+	content string
+	match   bool
+}
+
+func Test_LogFilterMatch(t *testing.T) {
+	logFilterTests := []*logFilterTest{
+		&logFilterTest{"", "anything", true},
+		&logFilterTest{"include=ERROR", "an ERROR occurred", true},
+		&logFilterTest{"include=ERROR", "an error occurred", false},
+		&logFilterTest{"include=ERROR&include=WARN", "WARN: careful", true},
+		&logFilterTest{"contains=req_id%3DABC", "GET / req_id=abc", true},
+		&logFilterTest{"contains=req_id%3Dabc", "GET / req_id=def", false},
+		&logFilterTest{"exclude=health", "GET /health", false},
+		&logFilterTest{"include=GET&exclude=health", "GET /users", true},
+		&logFilterTest{"include=GET&exclude=health", "GET /health", false},
+	}
+
+	for i, test := range logFilterTests {
+		values, _ := url.ParseQuery(test.query)
+		f, err := NewLogFilter(values)
+		if err != nil {
+			t.Errorf("Test %d : unexpected error %v", i, err)
+			continue
+		}
+		msg := rig.ProcessOutputMessage{Content: test.content}
+		if f.Match(msg) != test.match {
+			t.Errorf("Test %d : expected match of '%s' with '%s' to be %v", i, test.content, test.query, test.match)
+		}
+	}
+}
+
+func Test_LogFilterInvalidPattern(t *testing.T) {
+	if _, err := NewLogFilter(url.Values{"include": {"("}}); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+}
+
+func Test_LogFilterInvalidLevel(t *testing.T) {
+	_, err := NewLogFilter(url.Values{"level": {"foo"}})
+	if err == nil || !strings.HasPrefix(err.Error(), "Bad parameter") {
+		t.Errorf("Expected a bad parameter error for an unknown level, got %v", err)
+	}
+	if _, err := NewLogFilter(url.Values{"level": {"WARNING"}}); err != nil {
+		t.Errorf("Expected an alias of a level to be valid, got %v", err)
+	}
+}
+
+func Test_FilteredTail(t *testing.T) {
+	buf := ring.New(4)
+	buf.Value = rig.ProcessOutputMessage{Content: "ERROR a", Time: time.Now()}
+	buf = buf.Next()
+	buf.Value = rig.ProcessOutputMessage{Content: "ERROR b", Time: time.Now().Add(1)}
+	buf = buf.Next()
+	buf.Value = rig.ProcessOutputMessage{Content: "ERROR c", Time: time.Now().Add(2)}
+	buf = buf.Next()
+	buf.Value = rig.ProcessOutputMessage{Content: "INFO d", Time: time.Now().Add(3)}
+
+	f, _ := NewLogFilter(url.Values{"include": {"ERROR"}})
+	tail := f.Tail([]*ring.Ring{buf}, 2)
+	if len(tail) != 2 {
+		t.Fatalf("Expected len(tail) to be 2, got %d", len(tail))
+	}
+	if tail[0].Content != "ERROR b" {
+		t.Errorf("Expected tail[0] to be 'ERROR b', got '%v'", tail[0].Content)
+	}
+	if tail[1].Content != "ERROR c" {
+		t.Errorf("Expected tail[1] to be 'ERROR c', got '%v'", tail[1].Content)
+	}
+}
+
+func Test_StripANSI(t *testing.T) {
+	tests := map[string]string{
+		"plain":                           "plain",
+		"\x1b[31mred\x1b[0m":              "red",
+		"\x1b[1;32mbold green\x1b[m done": "bold green done",
+		"\x1b[2K\x1b[1Gprogress":          "progress",
+		"\x1b]0;title\x07text":            "text",
+		"\x1b(Bcharset":                   "charset",
+	}
+	for input, expected := range tests {
+		if output := StripANSI(input); output != expected {
+			t.Errorf("Expected %q to be stripped to %q, got %q", input, expected, output)
+		}
+	}
+}
+
+func Test_LogFilterStripANSI(t *testing.T) {
+	msg := rig.ProcessOutputMessage{Content: "\x1b[31mERROR\x1b[0m: boom"}
+
+	f, _ := NewLogFilter(url.Values{"include": {"^ERROR"}, "strip_ansi": {"true"}})
+	out, ok := f.Apply(msg)
+	if !ok {
+		t.Errorf("Expected the stripped line to match")
+	}
+	if out.Content != "ERROR: boom" {
+		t.Errorf("Expected 'ERROR: boom', got %q", out.Content)
+	}
+	if msg.Content != "\x1b[31mERROR\x1b[0m: boom" {
+		t.Errorf("Expected the original message to be left alone")
+	}
+
+	f, _ = NewLogFilter(url.Values{})
+	if out, _ := f.Apply(msg); out.Content != msg.Content {
+		t.Errorf("Expected escape sequences to be kept by default, got %q", out.Content)
+	}
+}
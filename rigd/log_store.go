@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/gocardless/rig"
+	"github.com/gocardless/rig/utils"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultLogStoreMaxSize = 10 * 1024 * 1024
+
+// Messages waiting to be written, beyond which new ones are dropped
+const logStoreBuffer = 10000
+
+// LogStore persists the output of processes to disk, one file of JSON lines
+// per process, so it can be searched long after it has left the in-memory
+// buffers. A slow disk doesn't hold up the processes: lines are dropped
+// instead.
+type LogStore struct {
+	sync.Mutex
+	dir           string
+	maxSize       int64
+	files         map[string]*os.File
+	msgCh         chan rig.ProcessOutputMessage
+	subscriptions map[*ProcessOutputDispatcher]*ProcessOutputSubscription
+	// Where the attached processes' output goes, relative to dir
+	paths   map[string]bool
+	dropped uint64
+}
+
+func NewLogStore() *LogStore {
+	st := &LogStore{
+		files:         make(map[string]*os.File),
+		msgCh:         make(chan rig.ProcessOutputMessage, logStoreBuffer),
+		subscriptions: make(map[*ProcessOutputDispatcher]*ProcessOutputSubscription),
+		paths:         make(map[string]bool),
+	}
+	go st.run()
+	return st
+}
+
+func (st *LogStore) Configure(config *Config) error {
+	dir := filepath.Join(filepath.Dir(config.Filename), "logs")
+	maxSize := int64(defaultLogStoreMaxSize)
+	if c := config.LogStore; c != nil {
+		if c.Disabled {
+			dir = ""
+		} else if c.Dir != "" {
+			dir = utils.ExpandPath(c.Dir)
+		}
+		if c.MaxSize > 0 {
+			maxSize = c.MaxSize
+		}
+	}
+
+	st.Lock()
+	defer st.Unlock()
+
+	if dir != st.dir {
+		st.closeFiles()
+	}
+	st.dir = dir
+	st.maxSize = maxSize
+
+	if st.dir == "" {
+		return nil
+	}
+	return os.MkdirAll(st.dir, 0755)
+}
+
+// Attach starts persisting the output of the given processes, and stops
+// persisting that of the processes which aren't given anymore. Processes
+// sharing a dispatcher with one already attached are skipped.
+func (st *LogStore) Attach(processes []*Process) {
+	st.Lock()
+	defer st.Unlock()
+
+	subscriptions := make(map[*ProcessOutputDispatcher]*ProcessOutputSubscription)
+	paths := make(map[string]bool)
+	for _, p := range processes {
+		s := st.subscriptions[p.outputDispatcher]
+		if s == nil {
+			s = p.outputDispatcher.SubscribeLossy(st.msgCh, nil)
+		}
+		subscriptions[p.outputDispatcher] = s
+		paths[logPath(p.Service.Stack.Name, p.Service.Name, p.Name)] = true
+	}
+
+	for d, s := range st.subscriptions {
+		if subscriptions[d] == nil {
+			s.End()
+			st.dropped += s.Dropped()
+		}
+	}
+	for path, f := range st.files {
+		if rel, err := filepath.Rel(st.dir, path); err == nil && !paths[rel] {
+			f.Close()
+			delete(st.files, path)
+		}
+	}
+	st.subscriptions = subscriptions
+	st.paths = paths
+}
+
+// Dropped returns the number of lines which weren't persisted because the
+// store couldn't keep up
+func (st *LogStore) Dropped() uint64 {
+	st.Lock()
+	defer st.Unlock()
+
+	dropped := st.dropped
+	for _, s := range st.subscriptions {
+		dropped += s.Dropped()
+	}
+	return dropped
+}
+
+func (st *LogStore) run() {
+	for msg := range st.msgCh {
+		if err := st.write(msg); err != nil {
+			log.Printf("[L] Error persisting output of %s:%s:%s: %v\n", msg.Stack, msg.Service, msg.Process, err)
+		}
+	}
+}
+
+func (st *LogStore) write(msg rig.ProcessOutputMessage) error {
+	st.Lock()
+	defer st.Unlock()
+
+	if st.dir == "" {
+		return nil
+	}
+
+	filename := st.filename(msg.Stack, msg.Service, msg.Process)
+	f, err := st.open(filename)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+
+	// The last lines of a process which was removed
+	if !st.paths[logPath(msg.Stack, msg.Service, msg.Process)] {
+		f.Close()
+		delete(st.files, filename)
+		return nil
+	}
+
+	if info, err := f.Stat(); err == nil && info.Size() >= st.maxSize {
+		f.Close()
+		delete(st.files, filename)
+		return os.Rename(filename, filename+".1")
+	}
+	return nil
+}
+
+// Must be called with the lock held
+func (st *LogStore) open(filename string) (*os.File, error) {
+	if f, exists := st.files[filename]; exists {
+		return f, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	st.files[filename] = f
+	return f, nil
+}
+
+// Must be called with the lock held
+func (st *LogStore) closeFiles() {
+	for filename, f := range st.files {
+		f.Close()
+		delete(st.files, filename)
+	}
+}
+
+func (st *LogStore) filename(stack, service, process string) string {
+	return filepath.Join(st.dir, logPath(stack, service, process))
+}
+
+func logPath(stack, service, process string) string {
+	return filepath.Join(stack, service, process+".log")
+}
+
+type SearchQuery struct {
+	Pattern *regexp.Regexp
+	Since   time.Time
+	Context int
+	Limit   int
+}
+
+// Search looks for lines matching the query in the persisted output of the
+// processes. Results from every process are merged in chronological order,
+// with Context lines around each match, and only the last Limit results are
+// kept.
+func (st *LogStore) Search(processes []*Process, query *SearchQuery) ([]*rig.ApiSearchResult, error) {
+	st.Lock()
+	dir := st.dir
+	st.Unlock()
+
+	results := []*rig.ApiSearchResult{}
+	if dir == "" {
+		return results, nil
+	}
+
+	for _, p := range processes {
+		filename := st.filename(p.Service.Stack.Name, p.Service.Name, p.Name)
+		for _, f := range []string{filename + ".1", filename} {
+			r, err := searchFile(f, query)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, r...)
+		}
+	}
+
+	sort.Stable(searchResultsByTime(results))
+
+	if query.Limit > 0 && len(results) > query.Limit {
+		results = results[len(results)-query.Limit:]
+	}
+	return results, nil
+}
+
+func searchFile(filename string, query *SearchQuery) ([]*rig.ApiSearchResult, error) {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var results, before []*rig.ApiSearchResult
+	after := 0
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		var msg rig.ProcessOutputMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			continue
+		}
+		if msg.Time.Before(query.Since) {
+			continue
+		}
+
+		result := &rig.ApiSearchResult{Message: msg}
+		if highlights := query.Pattern.FindAllStringIndex(msg.Content, -1); highlights != nil {
+			result.Match = true
+			result.Highlights = highlights
+			results = append(results, before...)
+			results = append(results, result)
+			before = nil
+			after = query.Context
+		} else if after > 0 {
+			results = append(results, result)
+			after--
+		} else if query.Context > 0 {
+			before = append(before, result)
+			if len(before) > query.Context {
+				before = before[1:]
+			}
+		}
+	}
+
+	return results, nil
+}
+
+type searchResultsByTime []*rig.ApiSearchResult
+
+func (r searchResultsByTime) Len() int           { return len(r) }
+func (r searchResultsByTime) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r searchResultsByTime) Less(i, j int) bool { return r[i].Message.Time.Before(r[j].Message.Time) }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"github.com/gocardless/rig"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func Test_LogStoreSearch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-store-test")
+	if err != nil {
+		t.Fatal("creating temp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	st := NewLogStore()
+	if err := st.Configure(&Config{Filename: path.Join(dir, "config.json")}); err != nil {
+		t.Fatal("configuring log store:", err)
+	}
+
+	stack := NewStack("acme")
+	svc := &Service{Name: "api", Stack: stack, Processes: make(map[string]*Process)}
+	web := NewProcess("web", "", svc)
+	worker := NewProcess("worker", "", svc)
+
+	now := time.Now()
+	for i, line := range []string{"booting", "GET /users", "Error: boom", "GET /health"} {
+		st.write(rig.ProcessOutputMessage{Content: line, Stack: "acme", Service: "api", Process: "web", Time: now.Add(time.Duration(2*i) * time.Second)})
+	}
+	st.write(rig.ProcessOutputMessage{Content: "job failed with error", Stack: "acme", Service: "api", Process: "worker", Time: now.Add(5 * time.Second)})
+	st.write(rig.ProcessOutputMessage{Content: "old error", Stack: "acme", Service: "api", Process: "worker", Time: now.Add(-48 * time.Hour)})
+
+	query := &SearchQuery{Pattern: regexp.MustCompile("(?i)error"), Since: now.Add(-time.Hour), Context: 1}
+	results, err := st.Search([]*Process{web, worker}, query)
+	if err != nil {
+		t.Fatal("searching:", err)
+	}
+
+	expected := []string{"GET /users", "Error: boom", "job failed with error", "GET /health"}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results, got %d", len(expected), len(results))
+	}
+	for i, content := range expected {
+		if results[i].Message.Content != content {
+			t.Errorf("Expected results[%d] to be '%s', got '%s'", i, content, results[i].Message.Content)
+		}
+	}
+	if !results[1].Match || results[0].Match {
+		t.Errorf("Expected only matching lines to be marked as matches")
+	}
+	if h := results[1].Highlights; len(h) != 1 || h[0][0] != 0 || h[0][1] != 5 {
+		t.Errorf("Expected results[1] to be highlighted at [0 5], got %v", h)
+	}
+}
+
+func Test_LogStoreAttach(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-store-test")
+	if err != nil {
+		t.Fatal("creating temp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	st := NewLogStore()
+	if err := st.Configure(&Config{Filename: path.Join(dir, "config.json")}); err != nil {
+		t.Fatal("configuring log store:", err)
+	}
+
+	stack := NewStack("acme")
+	svc := &Service{Name: "api", Stack: stack, Processes: make(map[string]*Process)}
+	web := NewProcess("web", "", svc)
+	worker := NewProcess("worker", "", svc)
+
+	st.Attach([]*Process{web, worker})
+	st.write(rig.ProcessOutputMessage{Content: "a", Stack: "acme", Service: "api", Process: "web", Time: time.Now()})
+	st.write(rig.ProcessOutputMessage{Content: "b", Stack: "acme", Service: "api", Process: "worker", Time: time.Now()})
+	if len(st.files) != 2 {
+		t.Errorf("Expected a file per process, got %d", len(st.files))
+	}
+
+	st.Attach([]*Process{web})
+	if worker.outputDispatcher.Subscribers() != 0 || web.outputDispatcher.Subscribers() != 1 {
+		t.Errorf("Expected only the attached process to be subscribed to")
+	}
+	if len(st.files) != 1 {
+		t.Errorf("Expected the file of the removed process to be closed, got %d files", len(st.files))
+	}
+	st.write(rig.ProcessOutputMessage{Content: "c", Stack: "acme", Service: "api", Process: "worker", Time: time.Now()})
+	if len(st.files) != 1 {
+		t.Errorf("Expected the late lines of the removed process not to keep its file open")
+	}
+
+	// A store which can't write doesn't hold up the process
+	st.Lock()
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < logStoreBuffer+10; i++ {
+			web.outputDispatcher.Publish(rig.ProcessOutputMessage{Content: "line", Stack: "acme", Service: "api", Process: "web", Time: time.Now()})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected publishing not to wait for the store")
+	}
+	st.Unlock()
+	if st.Dropped() == 0 {
+		t.Errorf("Expected the lines the store couldn't take to be counted")
+	}
+}
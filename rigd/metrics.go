@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Upper bounds of the API request latency histogram buckets, in seconds
+var requestLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 30}
+
+// ProcessStats counts the restarts of a process and remembers how it last
+// exited.
+type ProcessStats struct {
+	sync.Mutex
+	restarts   int
+	exited     bool
+	exitCode   int
+	exitReason string
+}
+
+func (s *ProcessStats) Restarted() {
+	s.Lock()
+	defer s.Unlock()
+	s.restarts++
+}
+
+// Exited records the exit code of the process and why it exited. Like shells
+// do, a process killed by a signal exits with 128 + the signal number.
+func (s *ProcessStats) Exited(state *os.ProcessState, oomKills int) {
+	if state == nil {
+		return
+	}
+
+	ws, ok := state.Sys().(syscall.WaitStatus)
+	if !ok {
+		return
+	}
+	code := ws.ExitStatus()
+	reason := fmt.Sprintf("exited with code %d", code)
+	if ws.Signaled() {
+		code = 128 + int(ws.Signal())
+		reason = fmt.Sprintf("killed by signal: %v", ws.Signal())
+	}
+	if oomKills > 0 {
+		reason = fmt.Sprintf("killed for running out of memory (%d OOM kills)", oomKills)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.exited = true
+	s.exitCode = code
+	s.exitReason = reason
+}
+
+// forgetExit forgets how the process last exited, before running it again
+func (s *ProcessStats) forgetExit() {
+	s.Lock()
+	defer s.Unlock()
+	s.exited = false
+	s.exitCode = 0
+	s.exitReason = ""
+}
+
+// ExitReason describes how the process last exited, if it did
+func (s *ProcessStats) ExitReason() string {
+	s.Lock()
+	defer s.Unlock()
+	return s.exitReason
+}
+
+func (s *ProcessStats) Restarts() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.restarts
+}
+
+// ExitCode returns the code the process last exited with, and false if it
+// never exited.
+func (s *ProcessStats) ExitCode() (int, bool) {
+	s.Lock()
+	defer s.Unlock()
+	return s.exitCode, s.exited
+}
+
+// RequestMetrics is a histogram of API request latencies by method and route.
+type RequestMetrics struct {
+	sync.Mutex
+	series map[requestKey]*latencyHistogram
+}
+
+type requestKey struct {
+	method string
+	route  string
+}
+
+type latencyHistogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func NewRequestMetrics() *RequestMetrics {
+	return &RequestMetrics{series: make(map[requestKey]*latencyHistogram)}
+}
+
+func (m *RequestMetrics) Observe(method, route string, d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	key := requestKey{method, route}
+	h := m.series[key]
+	if h == nil {
+		h = &latencyHistogram{counts: make([]uint64, len(requestLatencyBuckets))}
+		m.series[key] = h
+	}
+
+	secs := d.Seconds()
+	for i, le := range requestLatencyBuckets {
+		if secs <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += secs
+}
+
+func (m *RequestMetrics) write(w *metricsWriter) {
+	m.Lock()
+	defer m.Unlock()
+
+	keys := make([]requestKey, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].method < keys[j].method
+	})
+
+	name := "rig_http_request_duration_seconds"
+	w.header(name, "histogram", "Latency of API requests. Tails last as long as they're followed.")
+	for _, key := range keys {
+		h := m.series[key]
+		labels := []string{"method", key.method, "route", key.route}
+		for i, le := range requestLatencyBuckets {
+			w.sample(name+"_bucket", append(labels, "le", formatFloat(le)), float64(h.counts[i]))
+		}
+		w.sample(name+"_bucket", append(labels, "le", "+Inf"), float64(h.count))
+		w.sample(name+"_sum", labels, h.sum)
+		w.sample(name+"_count", labels, float64(h.count))
+	}
+}
+
+// WriteMetrics writes the state of rigd in the Prometheus text format.
+// Processes are labelled with their stack, service and process names.
+func (srv *Server) WriteMetrics(out io.Writer) error {
+	w := &metricsWriter{w: out}
+
+	processes := srv.allProcesses()
+	sort.Slice(processes, func(i, j int) bool { return processes[i].Fqd() < processes[j].Fqd() })
+
+	processMetric := func(name, typ, help string, value func(p *Process) (float64, bool)) {
+		w.header(name, typ, help)
+		for _, p := range processes {
+			if v, ok := value(p); ok {
+				w.sample(name, processLabels(p), v)
+			}
+		}
+	}
+
+	processMetric("rig_process_up", "gauge", "Whether the process is running.", func(p *Process) (float64, bool) {
+		if p.status() == Running {
+			return 1, true
+		}
+		return 0, true
+	})
+	processMetric("rig_process_uptime_seconds", "gauge", "Time since the process was started, while it's running.", func(p *Process) (float64, bool) {
+		st := p.state()
+		if st.status != Running {
+			return 0, false
+		}
+		return time.Since(st.startedAt).Seconds(), true
+	})
+	processMetric("rig_process_restarts_total", "counter", "Number of times the process was restarted.", func(p *Process) (float64, bool) {
+		return float64(p.stats.Restarts()), true
+	})
+	processMetric("rig_process_exit_code", "gauge", "Exit code of the last run of the process, 128 + the signal number if it was killed.", func(p *Process) (float64, bool) {
+		code, ok := p.stats.ExitCode()
+		return float64(code), ok
+	})
+	processMetric("rig_process_log_lines_total", "counter", "Number of log messages output by the process.", func(p *Process) (float64, bool) {
+		return float64(p.outputDispatcher.Published()), true
+	})
+	processMetric("rig_process_log_lines_dropped_total", "counter", "Number of log messages dropped because a tail, a sink or the log store couldn't keep up.", func(p *Process) (float64, bool) {
+		return float64(p.outputDispatcher.Dropped()), true
+	})
+	processMetric("rig_process_log_subscribers", "gauge", "Number of tails, sinks and stores receiving the output of the process.", func(p *Process) (float64, bool) {
+		return float64(p.outputDispatcher.Subscribers()), true
+	})
+	processMetric("rig_process_cpu_percent", "gauge", "CPU used by the process and its children, in percent of one core.", func(p *Process) (float64, bool) {
+		if r := p.resources.Latest(); r != nil && p.status() == Running {
+			return r.CPU, true
+		}
+		return 0, false
+	})
+	processMetric("rig_process_resident_memory_bytes", "gauge", "Resident memory of the process and its children.", func(p *Process) (float64, bool) {
+		if r := p.resources.Latest(); r != nil && p.status() == Running {
+			return float64(r.RSS), true
+		}
+		return 0, false
+	})
+
+	w.header("rig_log_store_lines_dropped_total", "counter", "Number of log messages the log store dropped because it couldn't keep up.")
+	w.sample("rig_log_store_lines_dropped_total", nil, float64(srv.logStore.Dropped()))
+
+	srv.requestMetrics.write(w)
+	return w.err
+}
+
+func processLabels(p *Process) []string {
+	return []string{"stack", p.Service.Stack.Name, "service", p.Service.Name, "process", p.Name}
+}
+
+// metricsWriter writes metrics in the Prometheus text format, remembering the
+// first error so callers only have to check once.
+type metricsWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *metricsWriter) header(name, typ, help string) {
+	w.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// Labels are given as name, value pairs
+func (w *metricsWriter) sample(name string, labels []string, value float64) {
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], escapeLabelValue(labels[i+1])))
+	}
+
+	if len(pairs) > 0 {
+		w.printf("%s{%s} %s\n", name, strings.Join(pairs, ","), formatFloat(value))
+	} else {
+		w.printf("%s %s\n", name, formatFloat(value))
+	}
+}
+
+func (w *metricsWriter) printf(format string, args ...interface{}) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = fmt.Fprintf(w.w, format, args...)
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"github.com/gocardless/rig"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_RequestMetricsHistogram(t *testing.T) {
+	m := NewRequestMetrics()
+	m.Observe("GET", "/ps", 2*time.Millisecond)
+	m.Observe("GET", "/ps", 2*time.Second)
+
+	var buf bytes.Buffer
+	w := &metricsWriter{w: &buf}
+	m.write(w)
+	out := buf.String()
+
+	for _, line := range []string{
+		`rig_http_request_duration_seconds_bucket{method="GET",route="/ps",le="0.001"} 0`,
+		`rig_http_request_duration_seconds_bucket{method="GET",route="/ps",le="0.005"} 1`,
+		`rig_http_request_duration_seconds_bucket{method="GET",route="/ps",le="5"} 2`,
+		`rig_http_request_duration_seconds_bucket{method="GET",route="/ps",le="+Inf"} 2`,
+		`rig_http_request_duration_seconds_count{method="GET",route="/ps"} 2`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected metrics to contain %s, got:\n%s", line, out)
+		}
+	}
+}
+
+func Test_WriteMetrics(t *testing.T) {
+	srv := NewServer()
+	stack := NewStack("acme")
+	svc := &Service{Name: "api", Stack: stack, Processes: map[string]*Process{}}
+	p := NewProcess("web", "true", svc)
+	svc.Processes["web"] = p
+	stack.Services["api"] = svc
+	srv.Stacks["acme"] = stack
+
+	p.stats.Restarted()
+	p.outputDispatcher.Publish(rig.ProcessOutputMessage{Content: "hello"})
+
+	var buf bytes.Buffer
+	if err := srv.WriteMetrics(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	out := buf.String()
+
+	for _, line := range []string{
+		`rig_process_up{stack="acme",service="api",process="web"} 0`,
+		`rig_process_restarts_total{stack="acme",service="api",process="web"} 1`,
+		`rig_process_log_lines_total{stack="acme",service="api",process="web"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected metrics to contain %s, got:\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, "rig_process_exit_code{") {
+		t.Errorf("Expected no exit code for a process which never ran")
+	}
+}
+
+func Test_EscapeLabelValue(t *testing.T) {
+	if v := escapeLabelValue("a\"b\\c\nd"); v != `a\"b\\c\nd` {
+		t.Errorf("Expected escaped value, got %s", v)
+	}
+}
+
+func Test_OnlySuccessfulRestartsAreCounted(t *testing.T) {
+	svc := &Service{Name: "api", Stack: NewStack("acme"), Config: &ServiceConfig{}}
+	p := NewProcess("web", "sleep 30", svc)
+	p.hooks = &HooksConfig{PreStart: "exit 1"}
+
+	if err := p.Restart(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// Starting, then stopped again once the hook fails
+	for deadline := time.Now().Add(30 * time.Second); time.Now().Before(deadline); {
+		p.mu.Lock()
+		restarting := p.restarting
+		p.mu.Unlock()
+		if !restarting {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := p.stats.Restarts(); n != 0 {
+		t.Errorf("Expected a failed restart not to be counted, got %d", n)
+	}
+
+	p.hooks = &HooksConfig{}
+	if err := p.Restart(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for deadline := time.Now().Add(30 * time.Second); p.status() != Running && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := p.stats.Restarts(); n != 1 {
+		t.Errorf("Expected the restart to be counted, got %d", n)
+	}
+	p.Stop()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMultilineTimeout  = 200 * time.Millisecond
+	defaultMultilineMaxLines = 500
+
+	// Longer lines are split into several messages
+	maxLineLength = 1024 * 1024
+)
+
+// Compiled multi-line grouping rules. A line continues the current record if
+// it's indented (when Indent is set) or if it doesn't match the start of
+// record pattern (when there is one). A record is flushed when a line starts a
+// new one, when no line has been read for Timeout, or when it reaches MaxLines.
+type MultilineRules struct {
+	Indent   bool
+	Start    *regexp.Regexp
+	Timeout  time.Duration
+	MaxLines int
+}
+
+func NewMultilineRules(config *MultilineConfig) (*MultilineRules, error) {
+	rules := &MultilineRules{
+		Indent:   config.Indent,
+		Timeout:  defaultMultilineTimeout,
+		MaxLines: defaultMultilineMaxLines,
+	}
+
+	if config.Start != "" {
+		re, err := regexp.Compile(config.Start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid multiline start pattern '%s': %v", config.Start, err)
+		}
+		rules.Start = re
+	}
+
+	if config.Timeout != "" {
+		timeout, err := time.ParseDuration(config.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid multiline timeout '%s': %v", config.Timeout, err)
+		}
+		rules.Timeout = timeout
+	}
+
+	if config.MaxLines > 0 {
+		rules.MaxLines = config.MaxLines
+	}
+
+	return rules, nil
+}
+
+func (r *MultilineRules) continues(line string) bool {
+	if r.Indent && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+		return true
+	}
+	return r.Start != nil && !r.Start.MatchString(line)
+}
+
+// LineGrouper groups lines into records according to the rules and emits
+// each record, joined with newlines, along with the time its first line was
+// read. Without rules every line is a record.
+type LineGrouper struct {
+	sync.Mutex
+	rules   *MultilineRules
+	emit    func(string, time.Time)
+	lines   []string
+	started time.Time
+	timer   *time.Timer
+}
+
+func NewLineGrouper(rules *MultilineRules, emit func(string, time.Time)) *LineGrouper {
+	return &LineGrouper{rules: rules, emit: emit}
+}
+
+func (g *LineGrouper) Add(line string) {
+	if g.rules == nil {
+		g.emit(line, time.Now())
+		return
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	if len(g.lines) > 0 && !g.rules.continues(line) {
+		g.flush()
+	}
+	if len(g.lines) == 0 {
+		g.started = time.Now()
+	}
+	g.lines = append(g.lines, line)
+	if len(g.lines) >= g.rules.MaxLines {
+		g.flush()
+		return
+	}
+
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+	g.timer = time.AfterFunc(g.rules.Timeout, g.Flush)
+}
+
+func (g *LineGrouper) Flush() {
+	g.Lock()
+	defer g.Unlock()
+	g.flush()
+}
+
+// Must be called with the lock held
+func (g *LineGrouper) flush() {
+	if g.timer != nil {
+		g.timer.Stop()
+		g.timer = nil
+	}
+	if len(g.lines) == 0 {
+		return
+	}
+	g.emit(strings.Join(g.lines, "\n"), g.started)
+	g.lines = nil
+}
+
+// readLines reads lines of any length from a stream, unlike bufio.Scanner
+// which gives up on lines over 64KB. Lines over maxLineLength are split.
+func readLines(stream io.Reader, f func(string)) error {
+	r := bufio.NewReader(stream)
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err == io.EOF {
+			if len(line) > 0 {
+				f(string(line))
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		line = append(line, chunk...)
+		if !isPrefix || len(line) >= maxLineLength {
+			f(string(line))
+			line = nil
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_GroupingIndentedLines(t *testing.T) {
+	records := groupLines(&MultilineRules{Indent: true, Timeout: time.Minute, MaxLines: 100}, []string{
+		"NoMethodError: undefined method",
+		"    app/models/user.rb:12",
+		"\tapp/controllers/users_controller.rb:5",
+		"Completed 500",
+	})
+
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d: %q", len(records), records)
+	}
+	if strings.Count(records[0], "\n") != 2 {
+		t.Errorf("Expected records[0] to have 3 lines, got %q", records[0])
+	}
+	if records[1] != "Completed 500" {
+		t.Errorf("Expected records[1] to be 'Completed 500', got %q", records[1])
+	}
+}
+
+func Test_GroupingByStartPattern(t *testing.T) {
+	rules := &MultilineRules{Start: regexp.MustCompile(`^\d{4}-`), Timeout: time.Minute, MaxLines: 100}
+	records := groupLines(rules, []string{
+		"2014-05-01 ERROR boom",
+		"java.lang.RuntimeException: boom",
+		"Caused by: java.io.IOException",
+		"2014-05-01 INFO ok",
+	})
+
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d: %q", len(records), records)
+	}
+	if !strings.HasSuffix(records[0], "Caused by: java.io.IOException") {
+		t.Errorf("Expected records[0] to include the cause, got %q", records[0])
+	}
+}
+
+func Test_GroupingMaxLines(t *testing.T) {
+	records := groupLines(&MultilineRules{Indent: true, Timeout: time.Minute, MaxLines: 2}, []string{
+		"a", " b", " c",
+	})
+
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d: %q", len(records), records)
+	}
+}
+
+func Test_GroupingTimeout(t *testing.T) {
+	records := make(chan string, 1)
+	g := NewLineGrouper(&MultilineRules{Indent: true, Timeout: 10 * time.Millisecond, MaxLines: 100}, func(content string, _ time.Time) {
+		records <- content
+	})
+	g.Add("a")
+
+	select {
+	case r := <-records:
+		if r != "a" {
+			t.Errorf("Expected 'a', got %q", r)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the record to be flushed after the timeout")
+	}
+}
+
+func Test_ReadingLongLines(t *testing.T) {
+	long := strings.Repeat("x", 100*1024)
+	var lines []string
+	err := readLines(strings.NewReader(long+"\nshort"), func(line string) {
+		lines = append(lines, line)
+	})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(lines) != 2 || lines[0] != long || lines[1] != "short" {
+		t.Errorf("Expected the long line and 'short', got %d lines", len(lines))
+	}
+}
+
+func groupLines(rules *MultilineRules, lines []string) []string {
+	var records []string
+	g := NewLineGrouper(rules, func(content string, _ time.Time) {
+		records = append(records, content)
+	})
+	for _, line := range lines {
+		g.Add(line)
+	}
+	g.Flush()
+	return records
+}
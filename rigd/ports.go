@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/gocardless/rig"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// How long killing a conflicting process waits for it to release its port
+// after SIGTERM, before sending SIGKILL
+const portReleaseTimeout = 5 * time.Second
+
+// A PortOwner is a process listening on a port. Pid is zero when the socket
+// can't be matched to a process, usually because it belongs to another user.
+// Managed is set when it's one of the processes rigd runs, or one of their
+// children.
+type PortOwner struct {
+	Pid     int
+	Command string
+	Managed *Process
+}
+
+func (o PortOwner) String() string {
+	if o.Pid == 0 {
+		return "a process rigd can't see"
+	}
+	if o.Managed != nil {
+		return fmt.Sprintf("%s (pid %d)", o.Managed.Fqd(), o.Pid)
+	}
+	if o.Command == "" {
+		return fmt.Sprintf("pid %d", o.Pid)
+	}
+	return fmt.Sprintf("pid %d (%s)", o.Pid, o.Command)
+}
+
+// PortOwners finds the processes listening on a TCP port, from the sockets
+// in /proc/net/tcp and tcp6 and the file descriptors of every process.
+func PortOwners(port int) ([]PortOwner, error) {
+	inodes := map[string]bool{}
+	for _, name := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		f, err := os.Open(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, inode := range listeningInodes(f, port) {
+			inodes[inode] = true
+		}
+		f.Close()
+	}
+	if len(inodes) == 0 {
+		return nil, nil
+	}
+
+	var owners []PortOwner
+	dirs, _ := filepath.Glob("/proc/[0-9]*")
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(filepath.Base(dir))
+		if err != nil {
+			continue
+		}
+		// Unreadable for processes of other users
+		fds, _ := ioutil.ReadDir(filepath.Join(dir, "fd"))
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(dir, "fd", fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inode := strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")
+			if inodes[inode] {
+				owners = append(owners, PortOwner{Pid: pid, Command: processCommand(pid)})
+				break
+			}
+		}
+	}
+	if len(owners) == 0 {
+		owners = append(owners, PortOwner{})
+	}
+	return owners, nil
+}
+
+// listeningInodes returns the inodes of the sockets listening on port in
+// the format of /proc/net/tcp
+func listeningInodes(r io.Reader, port int) []string {
+	var inodes []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+		if len(fields) < 10 || fields[3] != "0A" {
+			continue
+		}
+		i := strings.LastIndex(fields[1], ":")
+		if i < 0 {
+			continue
+		}
+		p, err := strconv.ParseInt(fields[1][i+1:], 16, 32)
+		if err != nil || int(p) != port {
+			continue
+		}
+		inodes = append(inodes, fields[9])
+	}
+	return inodes
+}
+
+func processCommand(pid int) string {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.Replace(string(b), "\x00", " ", -1))
+}
+
+// checkPort returns an error naming the processes listening on the port of
+// the process, if any. With kill set, it kills them instead, unless they're
+// in one of the managed process groups, which map process group ids to the
+// processes rigd runs in them.
+func (p *Process) checkPort(port int, kill bool, managed map[int]*Process) error {
+	if port == 0 {
+		return nil
+	}
+	owners, err := PortOwners(port)
+	if err != nil {
+		log.Printf("[P] Can't check port %d of %s: %v\n", port, p.Sqd(), err)
+		return nil
+	}
+	if len(owners) == 0 {
+		return nil
+	}
+
+	var names []string
+	for i, o := range owners {
+		if o.Pid != 0 {
+			if pgid, err := syscall.Getpgid(o.Pid); err == nil {
+				owners[i].Managed = managed[pgid]
+			}
+		}
+		names = append(names, owners[i].String())
+	}
+	if !kill {
+		return fmt.Errorf("Port %d of %s is already in use by %s", port, p.Sqd(), strings.Join(names, ", "))
+	}
+
+	for _, o := range owners {
+		if o.Managed != nil {
+			return fmt.Errorf("Port %d of %s is already in use by %s, which rig runs: stop it instead", port, p.Sqd(), o)
+		}
+		if o.Pid == 0 || o.Pid == os.Getpid() {
+			return fmt.Errorf("Port %d of %s is already in use by %s, which can't be killed", port, p.Sqd(), o)
+		}
+	}
+	for _, o := range owners {
+		log.Printf("[P] Killing %s, which listens on port %d of %s\n", o, port, p.Sqd())
+		syscall.Kill(o.Pid, syscall.SIGTERM)
+	}
+	for deadline := time.Now().Add(portReleaseTimeout); time.Now().Before(deadline); {
+		time.Sleep(100 * time.Millisecond)
+		if owners, _ := PortOwners(port); len(owners) == 0 {
+			return nil
+		}
+	}
+	for _, o := range owners {
+		syscall.Kill(o.Pid, syscall.SIGKILL)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if owners, _ := PortOwners(port); len(owners) != 0 {
+		return fmt.Errorf("Port %d of %s is still in use after killing %s", port, p.Sqd(), strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// CheckPorts checks that nothing else listens on the ports of the stopped
+// processes a descriptor refers to, so that starting them can fail with a
+// useful error. With kill set, whatever listens on them is killed instead,
+// except for processes rigd runs.
+func (srv *Server) CheckPorts(d *rig.Descriptor, kill bool) error {
+	processes, err := srv.Processes(d)
+	if err != nil {
+		return err
+	}
+	// Processes run in their own group, which their children share
+	managed := map[int]*Process{}
+	for _, p := range srv.allProcesses() {
+		if proc, ok := p.running(); ok {
+			managed[proc.Pid] = p
+		}
+	}
+
+	var errs []string
+	for _, p := range processes {
+		if p.status() != Stopped || p.LazyState() != "" {
+			continue
+		}
+		if err := p.checkPort(p.Port(), kill, managed); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		if !kill {
+			errs = append(errs, "Start with --kill-conflicts to kill them")
+		}
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"github.com/gocardless/rig"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ListeningInodes(t *testing.T) {
+	table := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:13BF 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 905 1 0000000044040601 100 0 0 10 0
+   1: 00000000:07E8 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 662 1 000000004abe7be2 100 0 0 10 0
+   2: 0100007F:BE72 0100007F:13BF 01 00000000:00000000 02:000008E6 00000000     0        0 99418 2 00000000598c2fcc 20 4 26 11 -1
+`
+	inodes := listeningInodes(strings.NewReader(table), 5055)
+	if len(inodes) != 1 || inodes[0] != "905" {
+		t.Errorf("Expected the listening socket 905, got %v", inodes)
+	}
+	if inodes := listeningInodes(strings.NewReader(table), 48754); len(inodes) != 0 {
+		t.Errorf("Expected connected sockets to be ignored, got %v", inodes)
+	}
+}
+
+func Test_PortOwners(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+
+	owners, err := PortOwners(port)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(owners) != 1 || owners[0].Pid != os.Getpid() {
+		t.Errorf("Expected the test to own the port, got %v", owners)
+	}
+
+	l.Close()
+	if owners, _ := PortOwners(port); len(owners) != 0 {
+		t.Errorf("Expected nothing to own the port anymore, got %v", owners)
+	}
+}
+
+func Test_ProcessCheckPort(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is needed to listen on a port")
+	}
+	port, err := freePort()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	cmd := exec.Command("python3", "-m", "http.server", strconv.Itoa(port), "--bind", "127.0.0.1")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	exited := make(chan bool)
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	defer cmd.Process.Kill()
+	for i := 0; i < 100; i++ {
+		if owners, _ := PortOwners(port); len(owners) != 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	svc := &Service{Name: "api", Stack: NewStack("acme"), Config: &ServiceConfig{Port: port}}
+	p := NewProcess("web", "true", svc)
+
+	err = p.checkPort(port, false, nil)
+	if err == nil || !strings.Contains(err.Error(), "pid "+strconv.Itoa(cmd.Process.Pid)) {
+		t.Errorf("Expected an error naming pid %d, got %v", cmd.Process.Pid, err)
+	}
+	if err := p.run(); err == nil {
+		t.Errorf("Expected the process not to start")
+	}
+
+	if err := p.checkPort(port, true, nil); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected the process listening on the port to be killed")
+	}
+}
+
+func Test_CheckPortsDoesntKillManagedProcesses(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is needed to listen on a port")
+	}
+	port, err := freePort()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	srv := NewServer()
+	for _, name := range []string{"acme", "other"} {
+		stack := NewStack(name)
+		svc := &Service{Name: "api", Stack: stack, Config: &ServiceConfig{Port: port}, Processes: map[string]*Process{}}
+		svc.Processes["web"] = NewProcess("web", "exec python3 -m http.server $PORT --bind 127.0.0.1", svc)
+		stack.Services["api"] = svc
+		srv.Stacks[name] = stack
+	}
+	web := srv.Stacks["acme"].Services["api"].Processes["web"]
+	go web.Start()
+	defer web.Stop()
+	for i := 0; i < 100; i++ {
+		if owners, _ := PortOwners(port); len(owners) != 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	err = srv.CheckPorts(&rig.Descriptor{Stack: "other"}, true)
+	if err == nil || !strings.Contains(err.Error(), web.Fqd()) {
+		t.Errorf("Expected an error naming %s, got %v", web.Fqd(), err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if web.status() != Running {
+		t.Errorf("Expected %s not to be killed", web.Fqd())
+	}
+}
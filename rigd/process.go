@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"container/ring"
 	"fmt"
 	"github.com/gocardless/rig"
@@ -19,19 +18,46 @@ import (
 const (
 	Stopped = iota
 	Running
+	// Running its pre_start hook, before its command runs
+	Starting
 )
 
 type ProcessStatus int
 
+// Status, Process, StartedAt, stdin, done and abortingStart change while the
+// process runs, and are read from other goroutines, so they're only accessed
+// with mu held or through state() and the other accessors.
 type Process struct {
+	mu               sync.Mutex
 	Name             string
 	Cmd              string
 	Service          *Service
 	Status           ProcessStatus
 	Process          *os.Process
+	StartedAt        time.Time
+	Config           *ProcessConfig
 	outputDispatcher *ProcessOutputDispatcher
-	buffer           *ring.Ring
-	bufferMutex      sync.Mutex
+	rawOutput        *RawOutputDispatcher
+	stdin            io.Writer
+	buffer           *LogBuffer
+	multiline        *MultilineRules
+	limits           *ProcessLimits
+	hooks            *HooksConfig
+	resources        *ResourceHistory
+	stats            *ProcessStats
+	runs             *RunHistory
+	schedule         Schedule
+	scheduler        *processScheduler
+	watch            *WatchRules
+	watcher          *Watcher
+	tty              *TTYSize
+	abortingStart    bool
+	restarting       bool // Counted as a restart once it runs again
+	hookTimeout      time.Duration
+	preStartHook     int // Process group of the running pre_start hook
+	lazy             *LazyRules
+	lazyListener     *lazyListener
+	done             chan bool
 }
 
 func NewProcess(name, cmd string, service *Service) *Process {
@@ -40,8 +66,14 @@ func NewProcess(name, cmd string, service *Service) *Process {
 		Cmd:              cmd,
 		Service:          service,
 		Status:           Stopped,
+		Config:           &ProcessConfig{},
+		hooks:            &HooksConfig{},
 		outputDispatcher: NewProcessOutputDispatcher(),
-		buffer:           ring.New(100),
+		rawOutput:        NewRawOutputDispatcher(),
+		buffer:           NewLogBuffer(defaultLogBufferLines, 0),
+		resources:        NewResourceHistory(),
+		stats:            &ProcessStats{},
+		runs:             &RunHistory{},
 	}
 }
 
@@ -54,49 +86,257 @@ func getUserShell() string {
 	return pw.Shell
 }
 
-func (p *Process) Start() error {
-	if p.Status != Stopped {
-		return fmt.Errorf("Process '%s' is already running", p.Sqd())
-	}
-
+// shellCommand runs a command with the user's login shell, as they would in
+// a terminal, in the service's dir and with the process's environment.
+func (p *Process) shellCommand(command string) *exec.Cmd {
 	shell := getUserShell()
 	var opts []string
 	switch filepath.Base(shell) {
 	case "zsh":
-		opts = []string{"-i", "-l", "-c", p.Cmd}
+		opts = []string{"-i", "-l", "-c", command}
 	default:
-		opts = []string{"-l", "-c", p.Cmd}
+		opts = []string{"-l", "-c", command}
 	}
+
 	cmd := exec.Command(shell, opts...)
 	cmd.Dir = p.Service.Dir
-	cmd.Env = os.Environ()
+	cmd.Env = p.Env()
+	return cmd
+}
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Fatal(err)
+// A processState is a snapshot of the fields of a process which change while
+// it runs
+type processState struct {
+	status    ProcessStatus
+	process   *os.Process
+	startedAt time.Time
+	stdin     io.Writer
+	done      chan bool
+}
+
+func (p *Process) state() processState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return processState{
+		status:    p.Status,
+		process:   p.Process,
+		startedAt: p.StartedAt,
+		stdin:     p.stdin,
+		done:      p.done,
 	}
+}
 
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		log.Fatal(err)
+func (p *Process) status() ProcessStatus {
+	return p.state().status
+}
+
+// running returns the OS process of the process while it's running
+func (p *Process) running() (*os.Process, bool) {
+	st := p.state()
+	return st.process, st.status == Running
+}
+
+// Env is the environment of the process: rigd's, with the variables rig
+// adds for the process.
+func (p *Process) Env() []string {
+	return append(os.Environ(), p.addedEnv()...)
+}
+
+// addedEnv is $PORT when the process has a port. A lazy process gets
+// another port, as rigd listens on its own.
+func (p *Process) addedEnv() []string {
+	var env []string
+	if port := p.listenPort(); port != 0 {
+		env = append(env, fmt.Sprintf("PORT=%d", port))
+	}
+	return env
+}
+
+// Start runs the process until it exits, or for a scheduled process starts
+// running it on its schedule. Watching its files starts with it, and carries
+// on if it exits by itself so that a fix can restart it. A lazy process only
+// starts listening on its port.
+func (p *Process) Start() error {
+	p.startWatch()
+	if p.schedule != nil {
+		return p.startSchedule()
+	}
+	if p.lazy != nil {
+		return p.startLazy()
+	}
+	return p.run()
+}
+
+func (p *Process) run() error {
+	return p.runFor(nil)
+}
+
+// runFor runs a lazy process woken up by s. It doesn't start if s has
+// stopped listening by the time the command would be spawned.
+func (p *Process) runFor(s *lazyListener) error {
+	p.mu.Lock()
+	if s != nil && p.lazyListener != s {
+		p.mu.Unlock()
+		return fmt.Errorf("Not starting %s: it isn't listening anymore", p.Sqd())
+	}
+	switch p.Status {
+	case Running:
+		p.mu.Unlock()
+		return fmt.Errorf("Process '%s' is already running", p.Sqd())
+	case Starting:
+		p.mu.Unlock()
+		return fmt.Errorf("Process '%s' is already starting", p.Sqd())
+	}
+	// Until the command runs, so that starting it again during its pre_start
+	// hook fails instead of running it twice
+	p.Status = Starting
+	p.abortingStart = false
+	p.mu.Unlock()
+	started := false
+	defer func() {
+		if !started {
+			p.mu.Lock()
+			p.Status = Stopped
+			p.restarting = false
+			p.mu.Unlock()
+		}
+	}()
+
+	if err := p.checkPort(p.listenPort(), false, nil); err != nil {
+		return err
+	}
+
+	if err := p.runHook(HookPreStart, p.hooks.PreStart); err != nil {
+		p.mu.Lock()
+		aborting := p.abortingStart
+		p.mu.Unlock()
+		if aborting {
+			return fmt.Errorf("Not starting %s: it was stopped while starting", p.Sqd())
+		}
+		return fmt.Errorf("Not starting %s: %s hook failed: %v", p.Sqd(), HookPreStart, err)
+	}
+
+	cmd := p.shellCommand(p.limits.wrapCommand(p.Cmd))
+	// Its own process group, so its children can be found
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	var pty *PTY
+	if p.tty != nil {
+		var err error
+		if pty, err = OpenPTY(p.tty); err != nil {
+			return fmt.Errorf("Error starting process %s: %v", p.Sqd(), err)
+		}
+		defer pty.Close()
+		pty.Attach(cmd)
+	}
+
+	var cgroup *Cgroup
+	if p.limits.needsCgroup() {
+		cg, err := NewCgroup(fmt.Sprintf("%s.%s.%s", p.Service.Stack.Name, p.Service.Name, p.Name), p.limits)
+		if err != nil {
+			log.Printf("[P] Starting %s without its memory, cpu and pids limits: %v\n", p.Sqd(), err)
+		} else {
+			cgroup = cg
+			cgroup.Apply(cmd.SysProcAttr)
+			defer cgroup.Close()
+		}
+	}
+
+	// Held until the command runs, so that it's either aborted by Stop() or
+	// can be stopped by it
+	p.mu.Lock()
+	if p.abortingStart || (s != nil && p.lazyListener != s) {
+		p.mu.Unlock()
+		return fmt.Errorf("Not starting %s: it was stopped while starting", p.Sqd())
+	}
+
+	var streams map[string]io.ReadCloser
+	if pty != nil {
+		// Under a pty, stdout and stderr are the same terminal
+		streams = map[string]io.ReadCloser{"stdout": pty}
+	} else {
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		stderr, err := cmd.StderrPipe()
+		if err != nil {
+			log.Fatal(err)
+		}
+		streams = map[string]io.ReadCloser{"stdout": stdout, "stderr": stderr}
+	}
+
+	// Held open for rig attach
+	var stdin io.Writer
+	if pty != nil {
+		stdin = pty
+	} else if p.Config.Stdin {
+		pipe, err := cmd.StdinPipe()
+		if err != nil {
+			log.Fatal(err)
+		}
+		stdin = pipe
 	}
 
 	log.Printf("[P] Starting process %s\n", p.Sqd())
 	if err := cmd.Start(); err != nil {
+		p.mu.Unlock()
 		return fmt.Errorf("Error starting process %s: %v", p.Sqd(), err)
 	}
+	if pty != nil {
+		pty.Started()
+	}
+	p.resources.Reset()
+	done := make(chan bool)
 	p.Process = cmd.Process
+	p.stdin = stdin
+	p.StartedAt = time.Now()
+	p.done = done
 	p.Status = Running
-	defer p.setStatus(Stopped)
+	restarted := p.restarting
+	p.restarting = false
+	p.mu.Unlock()
+	started = true
+	if restarted {
+		p.stats.Restarted()
+	}
+	defer func() {
+		p.mu.Lock()
+		p.stdin = nil
+		p.Status = Stopped
+		p.mu.Unlock()
+		close(done)
+	}()
+
+	go func() {
+		if err := p.runHook(HookPostStart, p.hooks.PostStart); err != nil {
+			log.Printf("[P] %s hook of %s failed: %v\n", HookPostStart, p.Sqd(), err)
+		}
+	}()
 
 	// Cmd.Wait() closes the fds, so we need to wait for reading to finish first
 	var wg sync.WaitGroup
-	wg.Add(2)
-	go p.logStream(stdout, "stdout", &wg)
-	go p.logStream(stderr, "stderr", &wg)
+	wg.Add(len(streams))
+	for name, stream := range streams {
+		go p.logStream(stream, name, &wg)
+	}
 	wg.Wait()
 
-	if err := cmd.Wait(); err != nil {
+	err := cmd.Wait()
+	oomKills := 0
+	if cgroup != nil {
+		oomKills = cgroup.OOMKills()
+	}
+	p.stats.Exited(cmd.ProcessState, oomKills)
+
+	if err := p.runHook(HookPostStop, p.hooks.PostStop); err != nil {
+		log.Printf("[P] %s hook of %s failed: %v\n", HookPostStop, p.Sqd(), err)
+	}
+
+	if oomKills > 0 {
+		return fmt.Errorf("%s failed: %s", p.Sqd(), p.stats.ExitReason())
+	} else if err != nil {
 		return fmt.Errorf("%s failed: %v", p.Sqd(), err)
 	} else {
 		log.Printf("[P] Process %s stopped\n", p.Sqd())
@@ -106,57 +346,167 @@ func (p *Process) Start() error {
 }
 
 func (p *Process) Stop() error {
-	if p.Status != Running {
+	unscheduled := p.stopSchedule()
+	unwatched := p.stopWatch()
+	unlistened := p.stopLazy()
+	if p.abortStart() {
+		return nil
+	}
+	if p.status() != Running {
+		if unscheduled || unwatched || unlistened {
+			return nil
+		}
 		return fmt.Errorf("Can't stop: %s isn't running", p.Sqd())
 	}
 
-	p.Process.Signal(syscall.SIGTERM)
+	return p.terminate()
+}
+
+// abortStart keeps a process which is starting from running its command, and
+// returns whether it was starting
+func (p *Process) abortStart() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Status != Starting {
+		return false
+	}
+	log.Printf("[P] Aborting the start of %s\n", p.Sqd())
+	p.abortingStart = true
+	if p.preStartHook != 0 {
+		syscall.Kill(-p.preStartHook, syscall.SIGKILL)
+	}
+	return true
+}
+
+func (p *Process) terminate() error {
+	proc, ok := p.running()
+	if !ok {
+		return fmt.Errorf("Can't stop: %s isn't running", p.Sqd())
+	}
+
+	if err := p.runHook(HookPreStop, p.hooks.PreStop); err != nil {
+		log.Printf("[P] %s hook of %s failed: %v\n", HookPreStop, p.Sqd(), err)
+	}
+
+	proc.Signal(syscall.SIGTERM)
 
 	return nil
 }
 
-func (p *Process) SubscribeToOutput(c chan rig.ProcessOutputMessage, num int) {
-	tailBuffer := MultiTail([]*ring.Ring{p.buffer}, num)
-	for _, msg := range tailBuffer {
-		c <- *msg
+// Restart stops the process if it's running, waits for it to exit, then
+// starts it again. Its files stay watched throughout. A lazy process which
+// is listening is only stopped, and starts again on the next connection.
+func (p *Process) Restart() error {
+	st := p.state()
+	if st.status == Starting {
+		return fmt.Errorf("Can't restart: %s is starting", p.Sqd())
 	}
-	p.outputDispatcher.Subscribe(c)
+	p.stopSchedule()
+	if p.listening() != nil {
+		if st.status == Running {
+			if err := p.terminate(); err != nil {
+				return err
+			}
+			p.setRestarting()
+		}
+		return nil
+	}
+	if st.status == Running {
+		if err := p.terminate(); err != nil {
+			return err
+		}
+		<-st.done
+	}
+
+	p.setRestarting()
+	go p.Start()
+	return nil
 }
 
-func (p *Process) setStatus(status ProcessStatus) {
-	p.Status = status
+// setRestarting counts the next time the process runs as a restart, so that
+// restarts which fail aren't counted
+func (p *Process) setRestarting() {
+	p.mu.Lock()
+	p.restarting = true
+	p.mu.Unlock()
 }
 
-func (p *Process) appendToBuffer(msg rig.ProcessOutputMessage) {
-	p.bufferMutex.Lock()
-	defer p.bufferMutex.Unlock()
+// Port is the process's $PORT: its own port setting, or for the web process
+// the service's.
+func (p *Process) Port() int {
+	if p.Config.Port != 0 {
+		return p.Config.Port
+	}
+	if p.Name == "web" {
+		return p.Service.Config.Port
+	}
+	return 0
+}
+
+func (p *Process) History(num int, filter *LogFilter) []*rig.ProcessOutputMessage {
+	return filter.Tail([]*ring.Ring{p.buffer.Ring()}, num)
+}
 
-	if p.buffer.Value != nil {
-		p.buffer = p.buffer.Next()
+func (p *Process) SubscribeToOutput(c chan rig.ProcessOutputMessage, num int, filter *LogFilter) ([]*rig.ProcessOutputMessage, []*ProcessOutputSubscription) {
+	return subscribeToOutput([]*Process{p}, c, num, filter)
+}
+
+func (p *Process) sampleResources(usages map[int]*groupUsage, at time.Time) {
+	proc, ok := p.running()
+	if !ok {
+		return
+	}
+	// It restarted since, or its group is gone
+	usage, ok := usages[proc.Pid]
+	if !ok {
+		return
 	}
-	p.buffer.Value = msg
+	p.resources.Add(usage, at)
+}
+
+func (p *Process) setStatus(status ProcessStatus) {
+	p.mu.Lock()
+	p.Status = status
+	p.mu.Unlock()
 }
 
 func (p *Process) logStream(stream io.ReadCloser, name string, wg *sync.WaitGroup) {
-	scanner := bufio.NewScanner(stream)
-	for scanner.Scan() {
-		msg := rig.ProcessOutputMessage{
-			Content: scanner.Text(),
-			Stack:   p.Service.Stack.Name,
-			Service: p.Service.Name,
-			Process: p.Name,
-			Time:    time.Now(),
-		}
-		p.outputDispatcher.Publish(msg)
-		p.appendToBuffer(msg)
-	}
-	if err := scanner.Err(); err != nil {
-		log.Printf("Error reading stdout for %s: %v\n", p.Sqd(), err)
+	grouper := NewLineGrouper(p.multiline, func(content string, t time.Time) {
+		p.publish(content, name, t)
+	})
+
+	if err := readLines(io.TeeReader(stream, p.rawOutput), grouper.Add); err != nil {
+		log.Printf("Error reading %s for %s: %v\n", name, p.Sqd(), err)
 	}
+	grouper.Flush()
 
 	wg.Done()
 }
 
+func (p *Process) publish(content, stream string, t time.Time) {
+	msg := rig.ProcessOutputMessage{
+		Content: content,
+		Stack:   p.Service.Stack.Name,
+		Service: p.Service.Name,
+		Process: p.Name,
+		Time:    t,
+		Stream:  stream,
+	}
+	parseStructuredLog(&msg, p.Config.LogFormat)
+	// Buffered before it's published, so a tail which subscribes and then
+	// reads the buffer can't miss it
+	p.buffer.Append(msg)
+	p.outputDispatcher.Publish(msg)
+}
+
+func (p *Process) descriptor() *rig.Descriptor {
+	return &rig.Descriptor{
+		Stack:   p.Service.Stack.Name,
+		Service: p.Service.Name,
+		Process: p.Name,
+	}
+}
+
 // Fully qualified descriptor: stack:service:process
 func (p *Process) Fqd() string {
 	return fmt.Sprintf("%s:%s:%s", p.Service.Stack.Name, p.Service.Name, p.Name)
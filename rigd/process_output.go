@@ -1,16 +1,21 @@
 package main
 
 import (
+	"container/ring"
 	"github.com/gocardless/rig"
 	"github.com/gocardless/rig/utils"
 	"sync"
+	"sync/atomic"
 )
 
 type ProcessOutputSubscription struct {
+	// First for 64-bit alignment, accessed atomically
+	dropped    uint64
 	id         string
 	dispatcher *ProcessOutputDispatcher
 	msgCh      chan rig.ProcessOutputMessage
-	endCh      chan bool
+	filter     *LogFilter
+	lossy      bool
 }
 
 func (s *ProcessOutputSubscription) End() {
@@ -19,7 +24,16 @@ func (s *ProcessOutputSubscription) End() {
 	s.dispatcher.Unlock()
 }
 
+// Number of messages dropped because the channel was full, for lossy
+// subscriptions
+func (s *ProcessOutputSubscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
 type ProcessOutputDispatcher struct {
+	// First for 64-bit alignment, accessed atomically
+	published uint64
+	dropped   uint64
 	sync.RWMutex
 	subscriptions map[string]*ProcessOutputSubscription
 }
@@ -30,12 +44,23 @@ func NewProcessOutputDispatcher() *ProcessOutputDispatcher {
 	}
 }
 
-func (d *ProcessOutputDispatcher) Subscribe(c chan rig.ProcessOutputMessage) *ProcessOutputSubscription {
+func (d *ProcessOutputDispatcher) Subscribe(c chan rig.ProcessOutputMessage, filter *LogFilter) *ProcessOutputSubscription {
+	return d.subscribe(c, filter, false)
+}
+
+// SubscribeLossy subscribes without ever blocking the publisher: messages
+// are dropped while c is full.
+func (d *ProcessOutputDispatcher) SubscribeLossy(c chan rig.ProcessOutputMessage, filter *LogFilter) *ProcessOutputSubscription {
+	return d.subscribe(c, filter, true)
+}
+
+func (d *ProcessOutputDispatcher) subscribe(c chan rig.ProcessOutputMessage, filter *LogFilter, lossy bool) *ProcessOutputSubscription {
 	s := &ProcessOutputSubscription{
 		id:         utils.GenerateId(),
 		dispatcher: d,
 		msgCh:      c,
-		endCh:      make(chan bool),
+		filter:     filter,
+		lossy:      lossy,
 	}
 
 	d.Lock()
@@ -45,17 +70,66 @@ func (d *ProcessOutputDispatcher) Subscribe(c chan rig.ProcessOutputMessage) *Pr
 }
 
 func (d *ProcessOutputDispatcher) Publish(message rig.ProcessOutputMessage) {
+	atomic.AddUint64(&d.published, 1)
 	d.RLock()
 	for _, s := range d.subscriptions {
-		s.msgCh <- message
+		message, ok := s.filter.Apply(message)
+		if !ok {
+			continue
+		}
+		if !s.lossy {
+			s.msgCh <- message
+			continue
+		}
+		select {
+		case s.msgCh <- message:
+		default:
+			atomic.AddUint64(&d.dropped, 1)
+			atomic.AddUint64(&s.dropped, 1)
+		}
 	}
 	d.RUnlock()
 }
 
-func (d *ProcessOutputDispatcher) End() {
+// Number of messages published
+func (d *ProcessOutputDispatcher) Published() uint64 {
+	return atomic.LoadUint64(&d.published)
+}
+
+// Number of messages dropped by lossy subscriptions
+func (d *ProcessOutputDispatcher) Dropped() uint64 {
+	return atomic.LoadUint64(&d.dropped)
+}
+
+func (d *ProcessOutputDispatcher) Subscribers() int {
 	d.RLock()
-	for _, s := range d.subscriptions {
-		s.endCh <- true
+	defer d.RUnlock()
+	return len(d.subscriptions)
+}
+
+// End unsubscribes everyone, once the process is gone
+func (d *ProcessOutputDispatcher) End() {
+	d.Lock()
+	d.subscriptions = make(map[string]*ProcessOutputSubscription)
+	d.Unlock()
+}
+
+// subscribeToOutput subscribes c to the output of the processes, then returns
+// their last num lines. Subscribing first means no line is missed, but the
+// most recent ones can be both in the history and sent to c. Tails are lossy,
+// so a slow client can't hold up the processes.
+func subscribeToOutput(processes []*Process, c chan rig.ProcessOutputMessage, num int, filter *LogFilter) ([]*rig.ProcessOutputMessage, []*ProcessOutputSubscription) {
+	var subs []*ProcessOutputSubscription
+	for _, p := range processes {
+		subs = append(subs, p.outputDispatcher.SubscribeLossy(c, filter))
 	}
-	d.RUnlock()
+	return historyOf(processes, num, filter), subs
+}
+
+func historyOf(processes []*Process, num int, filter *LogFilter) []*rig.ProcessOutputMessage {
+	var buffers []*ring.Ring
+	for _, p := range processes {
+		buffers = append(buffers, p.buffer.Ring())
+	}
+	return filter.Tail(buffers, num)
 }
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/gocardless/rig"
+	"github.com/gocardless/rig/utils"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	defaultProxyAddr      = ":80"
+	defaultProxyHTTPSAddr = ":443"
+	defaultProxyDomain    = "test"
+)
+
+// The Proxy routes requests to processes by hostname, so services are
+// reached at http://api.acme.test rather than by their port. WebSocket
+// upgrades are proxied too.
+type Proxy struct {
+	srv       *Server
+	config    ProxyConfig
+	domain    string
+	addr      string
+	httpsAddr string
+	ca        *CA
+	servers   []*http.Server
+	// The addresses it managed to listen on
+	listening map[string]bool
+}
+
+func NewProxy(srv *Server, config *ProxyConfig, configDir string) (*Proxy, error) {
+	p := &Proxy{
+		srv:       srv,
+		config:    *config,
+		domain:    strings.Trim(strings.ToLower(config.Domain), "."),
+		addr:      config.Addr,
+		listening: map[string]bool{},
+	}
+	if p.domain == "" {
+		p.domain = defaultProxyDomain
+	}
+	if p.addr == "" {
+		p.addr = defaultProxyAddr
+	}
+
+	if config.HTTPS {
+		p.httpsAddr = config.HTTPSAddr
+		if p.httpsAddr == "" {
+			p.httpsAddr = defaultProxyHTTPSAddr
+		}
+		dir := filepath.Join(configDir, "ca")
+		if config.CADir != "" {
+			dir = utils.ExpandPath(config.CADir)
+		}
+		ca, err := LoadCA(dir)
+		if err != nil {
+			return nil, err
+		}
+		p.ca = ca
+	}
+
+	return p, nil
+}
+
+// Start listens for requests in the background. An address which can't be
+// listened on is logged, as port 80 and 443 can require privileges. It
+// returns whether it listens on any.
+func (p *Proxy) Start() bool {
+	p.listen(p.addr, nil)
+	if p.ca != nil && p.listen(p.httpsAddr, &tls.Config{GetCertificate: p.getCertificate}) {
+		log.Printf("[X] Certificates are signed by the CA in %s\n", p.ca.CertFile)
+	}
+	return len(p.listening) > 0
+}
+
+func (p *Proxy) listen(addr string, tlsConfig *tls.Config) bool {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("[X] Proxy can't listen on %s: %v\n", addr, err)
+		return false
+	}
+	p.listening[addr] = true
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+
+	server := &http.Server{Handler: p, TLSConfig: tlsConfig}
+	p.servers = append(p.servers, server)
+	log.Printf("[X] Proxying *.%s on %s\n", p.domain, addr)
+	go func() {
+		if err := server.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Printf("[X] Proxy on %s stopped: %v\n", addr, err)
+		}
+	}()
+	return true
+}
+
+func (p *Proxy) Close() {
+	for _, server := range p.servers {
+		server.Close()
+	}
+	p.servers = nil
+	p.listening = map[string]bool{}
+}
+
+func (p *Proxy) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := strings.ToLower(hello.ServerName)
+	if !strings.HasSuffix(host, "."+p.domain) {
+		return nil, fmt.Errorf("%s isn't a .%s host", host, p.domain)
+	}
+	return p.ca.Certificate(host)
+}
+
+// Target finds the process a hostname refers to, and its port
+func (p *Proxy) Target(host string) (*Process, error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	if !strings.HasSuffix(host, "."+p.domain) {
+		return nil, fmt.Errorf("No such host %s: it doesn't end with .%s", host, p.domain)
+	}
+
+	d := &rig.Descriptor{Process: "web"}
+	labels := strings.Split(strings.TrimSuffix(host, "."+p.domain), ".")
+	switch len(labels) {
+	case 2:
+		d.Service, d.Stack = labels[0], labels[1]
+	case 3:
+		d.Process, d.Service, d.Stack = labels[0], labels[1], labels[2]
+	default:
+		return nil, fmt.Errorf("No such host %s: expected <service>.<stack>.%s", host, p.domain)
+	}
+
+	process, err := p.srv.GetProcess(d)
+	if err != nil {
+		return nil, fmt.Errorf("No such host %s: %v", host, err)
+	}
+	if process.Port() == 0 {
+		return nil, fmt.Errorf("No port for %s: set the port of the service or process", process.Fqd())
+	}
+	return process, nil
+}
+
+// URL is where a process can be reached through the proxy: over HTTPS if it
+// listens for it, and over HTTP otherwise.
+func (p *Proxy) URL(process *Process) string {
+	scheme, addr, defaultPort := "http", p.addr, "80"
+	if p.ca != nil && (p.listening[p.httpsAddr] || !p.listening[p.addr]) {
+		scheme, addr, defaultPort = "https", p.httpsAddr, "443"
+	}
+
+	host := fmt.Sprintf("%s.%s.%s", process.Service.Name, process.Service.Stack.Name, p.domain)
+	if process.Name != "web" {
+		host = process.Name + "." + host
+	}
+	if _, port, err := net.SplitHostPort(addr); err == nil && port != defaultPort {
+		host = net.JoinHostPort(host, port)
+	}
+	return scheme + "://" + host
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	process, err := p.Target(r.Host)
+	if err != nil {
+		status := http.StatusBadGateway
+		if strings.HasPrefix(err.Error(), "No such") {
+			status = http.StatusNotFound
+		}
+		writeProxyError(w, status, err.Error(), "")
+		return
+	}
+	// A lazy process starts on the connection to its port
+	if process.status() != Running && process.LazyState() == "" {
+		writeProxyError(w, http.StatusServiceUnavailable,
+			fmt.Sprintf("%s isn't running", process.Fqd()),
+			fmt.Sprintf("rig start %s", process.Fqd()))
+		return
+	}
+
+	target := &url.URL{Scheme: "http", Host: fmt.Sprintf("localhost:%d", process.Port())}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		req.Header.Set("X-Forwarded-Host", r.Host)
+		if r.TLS != nil {
+			req.Header.Set("X-Forwarded-Proto", "https")
+		} else {
+			req.Header.Set("X-Forwarded-Proto", "http")
+		}
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		log.Printf("[X] Error proxying %s to %s: %v\n", r.Host, process.Fqd(), err)
+		writeProxyError(w, http.StatusBadGateway,
+			fmt.Sprintf("%s isn't answering on port %d", process.Fqd(), process.Port()),
+			fmt.Sprintf("rig tail %s", process.Fqd()))
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+var proxyErrorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>rig: {{.Message}}</title>
+<style>
+  body { font-family: -apple-system, Helvetica, Arial, sans-serif; color: #333; max-width: 40em; margin: 4em auto; }
+  code { background: #f4f4f4; padding: 0.2em 0.4em; }
+</style>
+</head>
+<body>
+<h1>{{.Message}}</h1>
+{{if .Command}}<p>Try <code>{{.Command}}</code>, then reload this page.</p>{{end}}
+</body>
+</html>
+`))
+
+func writeProxyError(w http.ResponseWriter, status int, message, command string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	proxyErrorPage.Execute(w, struct{ Message, Command string }{message, command})
+}
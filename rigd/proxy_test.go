@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newProxyTestServer(port int) *Server {
+	srv := NewServer()
+	stack := NewStack("acme")
+	svc := &Service{Name: "api", Stack: stack, Config: &ServiceConfig{Port: port}, Processes: map[string]*Process{}}
+	svc.Processes["web"] = NewProcess("web", "rails server", svc)
+	svc.Processes["worker"] = NewProcess("worker", "rake jobs:work", svc)
+	stack.Services["api"] = svc
+	srv.Stacks["acme"] = stack
+	return srv
+}
+
+func Test_ProxyServeHTTP(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host + " " + r.Header.Get("X-Forwarded-Host") + " " + r.URL.Path))
+	}))
+	defer backend.Close()
+	_, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	n, _ := strconv.Atoi(port)
+
+	srv := newProxyTestServer(n)
+	proxy, err := NewProxy(srv, &ProxyConfig{}, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	get := func(host string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http://"+host+"/users", nil)
+		proxy.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := get("api.acme.test"); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "rig start acme:api:web") {
+		t.Errorf("Expected a page saying the process is stopped, got %d %s", w.Code, w.Body.String())
+	}
+
+	srv.Stacks["acme"].Services["api"].Processes["web"].setStatus(Running)
+	if w := get("API.acme.test"); w.Code != http.StatusOK || w.Body.String() != "API.acme.test API.acme.test /users" {
+		t.Errorf("Expected the request to be proxied, got %d %s", w.Code, w.Body.String())
+	}
+
+	for _, host := range []string{"nope.acme.test", "api.acme.dev", "acme.test"} {
+		if w := get(host); w.Code != http.StatusNotFound {
+			t.Errorf("Expected a 404 for %s, got %d", host, w.Code)
+		}
+	}
+	if w := get("worker.api.acme.test"); w.Code != http.StatusBadGateway {
+		t.Errorf("Expected a 502 for a process without a port, got %d", w.Code)
+	}
+}
+
+func Test_ProxyURL(t *testing.T) {
+	srv := newProxyTestServer(5000)
+	web := srv.Stacks["acme"].Services["api"].Processes["web"]
+
+	proxy, _ := NewProxy(srv, &ProxyConfig{}, "")
+	if u := proxy.URL(web); u != "http://api.acme.test" {
+		t.Errorf("Expected http://api.acme.test, got %s", u)
+	}
+	proxy, _ = NewProxy(srv, &ProxyConfig{Addr: ":8080", Domain: "localhost"}, "")
+	if u := proxy.URL(web); u != "http://api.acme.localhost:8080" {
+		t.Errorf("Expected http://api.acme.localhost:8080, got %s", u)
+	}
+}
+
+func Test_ProxyURLOfBoundAddresses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rig-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	srv := newProxyTestServer(5000)
+	web := srv.Stacks["acme"].Services["api"].Processes["web"]
+
+	proxy, _ := NewProxy(srv, &ProxyConfig{Addr: taken.Addr().String()}, dir)
+	if proxy.Start() {
+		t.Errorf("Expected the proxy not to start on a taken address")
+	}
+	proxy.Close()
+
+	// Without its HTTPS address, it's only reachable over HTTP
+	proxy, err = NewProxy(srv, &ProxyConfig{Addr: "127.0.0.1:0", HTTPS: true, HTTPSAddr: taken.Addr().String(), Domain: "localhost"}, dir)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !proxy.Start() {
+		t.Errorf("Expected the proxy to start on its HTTP address")
+	}
+	defer proxy.Close()
+	if u := proxy.URL(web); !strings.HasPrefix(u, "http://api.acme.localhost") {
+		t.Errorf("Expected an HTTP url, got %s", u)
+	}
+}
+
+func Test_CA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rig-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, err := LoadCA(dir)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	cert, err := ca.Certificate("api.acme.test")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// The CA is kept, so certificates still verify after a restart
+	ca, err = LoadCA(dir)
+	if err != nil {
+		t.Fatalf("Expected no error loading the CA, got %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: "api.acme.test", Roots: roots}); err != nil {
+		t.Errorf("Expected the certificate to verify, got %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+// #define _XOPEN_SOURCE 600
+// #include <fcntl.h>
+// #include <stdlib.h>
+// #include <termios.h>
+//
+// // Output isn't translated from \n to \r\n, so lines split as with pipes
+// static int rig_raw_output(int fd) {
+//   struct termios t;
+//   if (tcgetattr(fd, &t) != 0) return -1;
+//   t.c_oflag &= ~ONLCR;
+//   return tcsetattr(fd, TCSANOW, &t);
+// }
+import "C"
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	defaultTTYColumns = 80
+	defaultTTYRows    = 24
+)
+
+// ptsname isn't reentrant
+var ptsnameLock sync.Mutex
+
+// Window size of the terminal of a process running under a PTY
+type TTYSize struct {
+	Columns int
+	Rows    int
+}
+
+// ParseTTYSize parses a size such as "120x40", in columns and rows. An empty
+// size is the default, 80x24.
+func ParseTTYSize(size string) (*TTYSize, error) {
+	if size == "" {
+		return &TTYSize{defaultTTYColumns, defaultTTYRows}, nil
+	}
+	parts := strings.Split(size, "x")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid tty size '%s': expected COLUMNSxROWS", size)
+	}
+	columns, err := strconv.Atoi(parts[0])
+	if err != nil || columns <= 0 || columns > 0xffff {
+		return nil, fmt.Errorf("invalid tty size '%s'", size)
+	}
+	rows, err := strconv.Atoi(parts[1])
+	if err != nil || rows <= 0 || rows > 0xffff {
+		return nil, fmt.Errorf("invalid tty size '%s'", size)
+	}
+	return &TTYSize{columns, rows}, nil
+}
+
+// A PTY is a pseudo-terminal: a process writes to the terminal end, and
+// rigd reads its output from the master end.
+type PTY struct {
+	master   *os.File
+	terminal *os.File
+}
+
+func OpenPTY(size *TTYSize) (*PTY, error) {
+	fd, err := C.posix_openpt(C.O_RDWR | C.O_NOCTTY)
+	if fd < 0 {
+		return nil, fmt.Errorf("Error opening a pty: %v", err)
+	}
+	master := os.NewFile(uintptr(fd), "/dev/ptmx")
+
+	if rc, err := C.grantpt(fd); rc != 0 {
+		master.Close()
+		return nil, fmt.Errorf("Error opening a pty: grantpt: %v", err)
+	}
+	if rc, err := C.unlockpt(fd); rc != 0 {
+		master.Close()
+		return nil, fmt.Errorf("Error opening a pty: unlockpt: %v", err)
+	}
+
+	ptsnameLock.Lock()
+	name := C.GoString(C.ptsname(fd))
+	ptsnameLock.Unlock()
+
+	terminal, err := os.OpenFile(name, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, fmt.Errorf("Error opening a pty: %v", err)
+	}
+	pty := &PTY{master: master, terminal: terminal}
+
+	if rc, err := C.rig_raw_output(C.int(terminal.Fd())); rc != 0 {
+		pty.Close()
+		return nil, fmt.Errorf("Error setting up the pty: %v", err)
+	}
+	if err := pty.Resize(size); err != nil {
+		pty.Close()
+		return nil, err
+	}
+	return pty, nil
+}
+
+func (t *PTY) Resize(size *TTYSize) error {
+	ws := struct{ Rows, Columns, X, Y uint16 }{uint16(size.Rows), uint16(size.Columns), 0, 0}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, t.master.Fd(), uintptr(syscall.TIOCSWINSZ), uintptr(unsafe.Pointer(&ws)))
+	if errno != 0 {
+		return fmt.Errorf("Error setting the pty's size: %v", errno)
+	}
+	return nil
+}
+
+// Attach makes the terminal the stdin, stdout, stderr and controlling
+// terminal of cmd, which runs in a session of its own. The session is also a
+// process group, led by the process.
+func (t *PTY) Attach(cmd *exec.Cmd) {
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = t.terminal, t.terminal, t.terminal
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	for _, v := range env {
+		if strings.HasPrefix(v, "TERM=") {
+			return
+		}
+	}
+	cmd.Env = append(env, "TERM=xterm-256color")
+}
+
+// Started closes rigd's copy of the terminal end once the process has it, so
+// reading reaches the end when the process and its children exit.
+func (t *PTY) Started() {
+	t.terminal.Close()
+}
+
+// Read reads the output of the process. Once the terminal end is closed,
+// Linux fails reads with EIO, which is the end of the output.
+func (t *PTY) Read(b []byte) (int, error) {
+	n, err := t.master.Read(b)
+	if pe, ok := err.(*os.PathError); ok && pe.Err == syscall.EIO {
+		return n, io.EOF
+	}
+	return n, err
+}
+
+// Write writes to the process's input
+func (t *PTY) Write(b []byte) (int, error) {
+	return t.master.Write(b)
+}
+
+func (t *PTY) Close() error {
+	t.terminal.Close()
+	return t.master.Close()
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_ParseTTYSize(t *testing.T) {
+	size, err := ParseTTYSize("120x40")
+	if err != nil || size.Columns != 120 || size.Rows != 40 {
+		t.Errorf("Expected 120 columns and 40 rows, got %+v (%v)", size, err)
+	}
+	size, err = ParseTTYSize("")
+	if err != nil || size.Columns != 80 || size.Rows != 24 {
+		t.Errorf("Expected the default size, got %+v (%v)", size, err)
+	}
+	for _, s := range []string{"120", "0x40", "ax40", "120x40x1", "120x70000"} {
+		if _, err := ParseTTYSize(s); err == nil {
+			t.Errorf("Expected an error for '%s'", s)
+		}
+	}
+}
+
+func Test_ProcessTTY(t *testing.T) {
+	svc := &Service{Name: "api", Stack: NewStack("acme"), Config: &ServiceConfig{}}
+	p := NewProcess("web", "test -t 1 && echo tty; stty size; echo err >&2", svc)
+	p.tty = &TTYSize{Columns: 120, Rows: 40}
+
+	if err := p.Start(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var lines []string
+	for _, msg := range p.History(10, nil) {
+		if msg.Stream != "stdout" {
+			t.Errorf("Expected all output on stdout, got %s", msg.Stream)
+		}
+		lines = append(lines, msg.Content)
+	}
+	// A login shell can print more, such as a motd
+	output := strings.Join(lines, "\n")
+	if expected := "tty\n40 120\nerr"; !strings.HasSuffix(output, expected) {
+		t.Errorf("Expected the output to end with %q, got %q", expected, output)
+	}
+}
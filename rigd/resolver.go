@@ -3,7 +3,9 @@ package main
 import (
 	"fmt"
 	"github.com/gocardless/rig"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -162,3 +164,139 @@ func (r *Resolver) GetDescriptor() (*rig.Descriptor, error) {
 	}
 	return d, nil
 }
+
+// GetDescriptors resolves a descriptor which can be a glob, such as
+// "acme:*:worker", "*:api" or "acme:{api,website}:web", to every stack,
+// service or process it matches. Globs are matched from the stack down, or
+// from the service down in the current service's stack when they start with
+// a colon. Other descriptors resolve like GetDescriptor.
+func (r *Resolver) GetDescriptors() ([]*rig.Descriptor, error) {
+	if !isGlob(r.str) {
+		d, err := r.GetDescriptor()
+		if err != nil {
+			return nil, err
+		}
+		return []*rig.Descriptor{d}, nil
+	}
+
+	seen := make(map[rig.Descriptor]bool)
+	var descriptors []*rig.Descriptor
+	for _, pattern := range expandBraces(r.str) {
+		matches, err := r.match(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range matches {
+			if !seen[*d] {
+				seen[*d] = true
+				descriptors = append(descriptors, d)
+			}
+		}
+	}
+	if len(descriptors) == 0 {
+		return nil, fmt.Errorf("No such stack, service or process matches '%s'", r.str)
+	}
+
+	sort.Slice(descriptors, func(i, j int) bool {
+		a, b := descriptors[i], descriptors[j]
+		if a.Stack != b.Stack {
+			return a.Stack < b.Stack
+		}
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		return a.Process < b.Process
+	})
+	return descriptors, nil
+}
+
+func (r *Resolver) match(pattern string) ([]*rig.Descriptor, error) {
+	parts := strings.SplitN(pattern, ":", 3)
+	stacks := r.stacks
+	if parts[0] == "" && len(parts) > 1 {
+		curSvc := r.findServiceByDir()
+		if curSvc == nil {
+			return nil, fmt.Errorf("Can't use sibling specifier here")
+		}
+		stacks = map[string]*Stack{curSvc.Stack.Name: curSvc.Stack}
+		parts[0] = curSvc.Stack.Name
+	}
+
+	for _, part := range parts {
+		if _, err := path.Match(part, ""); err != nil {
+			return nil, fmt.Errorf("Bad parameter: invalid pattern '%s'", part)
+		}
+	}
+
+	var descriptors []*rig.Descriptor
+	for _, s := range stacks {
+		if ok, _ := path.Match(parts[0], s.Name); !ok {
+			continue
+		}
+		if len(parts) == 1 {
+			descriptors = append(descriptors, &rig.Descriptor{Stack: s.Name})
+			continue
+		}
+		for _, svc := range s.Services {
+			if ok, _ := path.Match(parts[1], svc.Name); !ok {
+				continue
+			}
+			if len(parts) == 2 {
+				descriptors = append(descriptors, &rig.Descriptor{Stack: s.Name, Service: svc.Name})
+				continue
+			}
+			for _, p := range svc.Processes {
+				if ok, _ := path.Match(parts[2], p.Name); !ok {
+					continue
+				}
+				descriptors = append(descriptors, &rig.Descriptor{Stack: s.Name, Service: svc.Name, Process: p.Name})
+			}
+		}
+	}
+	return descriptors, nil
+}
+
+func isGlob(str string) bool {
+	return strings.ContainsAny(str, "*?[{")
+}
+
+// expandBraces expands the first {a,b} alternatives of a pattern, and those
+// in each of the results, so "{api,web}:{x,y}" gives four patterns.
+func expandBraces(pattern string) []string {
+	start := strings.Index(pattern, "{")
+	if start < 0 {
+		return []string{pattern}
+	}
+
+	var alternatives []string
+	depth, last, end := 0, start+1, -1
+scan:
+	for i := start; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '{':
+			depth++
+		case ',':
+			if depth == 1 {
+				alternatives = append(alternatives, pattern[last:i])
+				last = i + 1
+			}
+		case '}':
+			depth--
+			if depth == 0 {
+				end = i
+				break scan
+			}
+		}
+	}
+	if end < 0 {
+		// Unbalanced, so matched literally
+		return []string{pattern}
+	}
+	alternatives = append(alternatives, pattern[last:end])
+
+	var expanded []string
+	for _, alternative := range alternatives {
+		expanded = append(expanded, expandBraces(pattern[:start]+alternative+pattern[end+1:])...)
+	}
+	return expanded
+}
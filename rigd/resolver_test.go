@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 	"testing"
 )
 
@@ -133,6 +134,93 @@ func Test_ResolvingInvalidContextualSibling(t *testing.T) {
 	})
 }
 
+// === Glob resolution tests
+
+func Test_ResolvingGlobs(t *testing.T) {
+	tests := map[string][]rig.Descriptor{
+		"stack1:*:process1": {
+			{Stack: "stack1", Service: "service1", Process: "process1"},
+			{Stack: "stack1", Service: "service2", Process: "process1"},
+		},
+		"*:service3": {
+			{Stack: "default", Service: "service3"},
+		},
+		"*": {
+			{Stack: "default"},
+			{Stack: "stack1"},
+		},
+		"stack1:{service2,service1}": {
+			{Stack: "stack1", Service: "service1"},
+			{Stack: "stack1", Service: "service2"},
+		},
+		"{stack1:service?,*:service1}": {
+			{Stack: "stack1", Service: "service1"},
+			{Stack: "stack1", Service: "service2"},
+		},
+		"service3": {
+			{Stack: "default", Service: "service3"},
+		},
+	}
+
+	withStacks(t, func(s map[string]*Stack) {
+		for str, expected := range tests {
+			descriptors, err := NewResolver(s, str, "/").GetDescriptors()
+			if err != nil {
+				t.Errorf("%s: resolution error: %v", str, err)
+				continue
+			}
+			if len(descriptors) != len(expected) {
+				t.Errorf("%s: expected %+v, got %d descriptors", str, expected, len(descriptors))
+				continue
+			}
+			for i, d := range descriptors {
+				if *d != expected[i] {
+					t.Errorf("%s: expected %+v, got %+v", str, expected[i], d)
+				}
+			}
+		}
+	})
+}
+
+func Test_ResolvingContextualSiblingGlob(t *testing.T) {
+	withStacks(t, func(s map[string]*Stack) {
+		dir := path.Join(tmpDir, "projects", "srv2")
+		descriptors, err := NewResolver(s, ":*:process1", dir).GetDescriptors()
+		if err != nil {
+			t.Fatalf("Resolution error: %v", err)
+		}
+		if len(descriptors) != 2 || descriptors[0].Stack != "stack1" || descriptors[1].Service != "service2" {
+			t.Errorf("Expected the processes of stack1, got %+v", descriptors)
+		}
+	})
+}
+
+func Test_ResolvingGlobWithoutMatches(t *testing.T) {
+	withStacks(t, func(s map[string]*Stack) {
+		for _, str := range []string{"xstack*", "stack1:*:xprocess", "stack1:[", ":*"} {
+			if _, err := NewResolver(s, str, "/").GetDescriptors(); err == nil {
+				t.Errorf("%s: expected a resolution error", str)
+			}
+		}
+	})
+}
+
+func Test_ExpandBraces(t *testing.T) {
+	tests := map[string][]string{
+		"acme":              {"acme"},
+		"acme:{api,web}":    {"acme:api", "acme:web"},
+		"{a,b}:{x,y}":       {"a:x", "a:y", "b:x", "b:y"},
+		"acme:{api,w{1,2}}": {"acme:api", "acme:w1", "acme:w2"},
+		"acme:{api":         {"acme:{api"},
+	}
+	for pattern, expected := range tests {
+		expanded := expandBraces(pattern)
+		if strings.Join(expanded, " ") != strings.Join(expected, " ") {
+			t.Errorf("%s: expected %v, got %v", pattern, expected, expanded)
+		}
+	}
+}
+
 // === Test helpers
 
 func checkSimpleResolution(t *testing.T, str string, example *rig.Descriptor) {
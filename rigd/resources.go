@@ -0,0 +1,110 @@
+package main
+
+import (
+	"github.com/gocardless/rig"
+	"sync"
+	"time"
+)
+
+const (
+	resourceSampleInterval = 2 * time.Second
+	// Two minutes of history at the default interval
+	resourceHistoryLength = 60
+)
+
+// A snapshot of the resources used by a process group, as read from the OS.
+// CPU is the total user and system time consumed so far.
+type groupUsage struct {
+	CPU      time.Duration
+	RSS      uint64
+	Threads  int
+	FDs      int
+	Children []int
+}
+
+// ResourceHistory keeps the most recent resource samples of a process.
+type ResourceHistory struct {
+	sync.Mutex
+	samples []rig.ApiResourceSample
+	lastCPU time.Duration
+	lastAt  time.Time
+}
+
+func NewResourceHistory() *ResourceHistory {
+	return &ResourceHistory{}
+}
+
+// Add records a sample. CPU% is the CPU time used since the previous sample
+// over the time elapsed, so it can go over 100 on multiple cores.
+func (h *ResourceHistory) Add(usage *groupUsage, at time.Time) {
+	h.Lock()
+	defer h.Unlock()
+
+	sample := rig.ApiResourceSample{
+		Time:     at,
+		RSS:      usage.RSS,
+		Threads:  usage.Threads,
+		FDs:      usage.FDs,
+		Children: usage.Children,
+	}
+	if !h.lastAt.IsZero() && at.After(h.lastAt) && usage.CPU >= h.lastCPU {
+		sample.CPU = 100 * float64(usage.CPU-h.lastCPU) / float64(at.Sub(h.lastAt))
+	}
+	h.lastCPU = usage.CPU
+	h.lastAt = at
+
+	h.samples = append(h.samples, sample)
+	if len(h.samples) > resourceHistoryLength {
+		h.samples = h.samples[len(h.samples)-resourceHistoryLength:]
+	}
+}
+
+// Reset forgets the CPU time of the previous process, so a restarted process
+// doesn't start off with a bogus CPU%.
+func (h *ResourceHistory) Reset() {
+	h.Lock()
+	defer h.Unlock()
+	h.lastCPU = 0
+	h.lastAt = time.Time{}
+}
+
+func (h *ResourceHistory) Latest() *rig.ApiResourceSample {
+	h.Lock()
+	defer h.Unlock()
+	if len(h.samples) == 0 {
+		return nil
+	}
+	sample := h.samples[len(h.samples)-1]
+	return &sample
+}
+
+func (h *ResourceHistory) Samples() []rig.ApiResourceSample {
+	h.Lock()
+	defer h.Unlock()
+	return append([]rig.ApiResourceSample{}, h.samples...)
+}
+
+// SampleResources samples the resources of every running process forever.
+// The processes of the system are read once per sample for all of them.
+func (srv *Server) SampleResources(interval time.Duration) {
+	for range time.Tick(interval) {
+		processes := srv.allProcesses()
+		var pgids []int
+		for _, p := range processes {
+			if proc, ok := p.running(); ok {
+				pgids = append(pgids, proc.Pid)
+			}
+		}
+		if len(pgids) == 0 {
+			continue
+		}
+		usages, err := readGroupUsages(pgids)
+		if err != nil {
+			continue
+		}
+		now := time.Now()
+		for _, p := range processes {
+			p.sampleResources(usages, now)
+		}
+	}
+}
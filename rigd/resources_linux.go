@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The kernel reports CPU time in clock ticks, which are 1/100s on every
+// architecture Linux runs rig on.
+const clockTicks = 100
+
+// Fields of /proc/<pid>/stat, numbered from the state field, which follows
+// the command name.
+const (
+	statPgrp       = 2
+	statUtime      = 11
+	statStime      = 12
+	statNumThreads = 17
+	statRSS        = 21
+)
+
+type procStat struct {
+	Pid     int
+	Pgrp    int
+	CPU     time.Duration
+	Threads int
+	RSS     uint64
+}
+
+// readGroupUsage adds up the resources used by every process in the process
+// group pgid, which processes are started in so their children can be found.
+func readGroupUsage(pgid int) (*groupUsage, error) {
+	usages, err := readGroupUsages([]int{pgid})
+	if err != nil {
+		return nil, err
+	}
+	usage, ok := usages[pgid]
+	if !ok {
+		return nil, fmt.Errorf("No processes in group %d", pgid)
+	}
+	return usage, nil
+}
+
+// readGroupUsages is readGroupUsage for several process groups, reading the
+// stats of every process once. Groups without processes are left out.
+func readGroupUsages(pgids []int) (map[int]*groupUsage, error) {
+	names, err := readDirNames("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	usages := make(map[int]*groupUsage)
+	for _, pgid := range pgids {
+		usages[pgid] = nil
+	}
+	for _, name := range names {
+		pid, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		stat, err := readProcStat(pid)
+		if err != nil {
+			// Processes can exit while we're looking at them
+			continue
+		}
+		usage, wanted := usages[stat.Pgrp]
+		if !wanted {
+			continue
+		}
+		if usage == nil {
+			usage = &groupUsage{}
+			usages[stat.Pgrp] = usage
+		}
+
+		usage.CPU += stat.CPU
+		usage.RSS += stat.RSS
+		usage.Threads += stat.Threads
+		usage.FDs += countFDs(pid)
+		if pid != stat.Pgrp {
+			usage.Children = append(usage.Children, pid)
+		}
+	}
+
+	for pgid, usage := range usages {
+		if usage == nil {
+			delete(usages, pgid)
+		}
+	}
+	return usages, nil
+}
+
+func readProcStat(pid int) (*procStat, error) {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	return parseProcStat(pid, string(b))
+}
+
+func parseProcStat(pid int, stat string) (*procStat, error) {
+	// The command name is in parentheses and can contain spaces and
+	// parentheses itself, so look for the last one
+	i := strings.LastIndex(stat, ")")
+	if i < 0 {
+		return nil, fmt.Errorf("Invalid stat for %d: %s", pid, stat)
+	}
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) <= statRSS {
+		return nil, fmt.Errorf("Invalid stat for %d: %s", pid, stat)
+	}
+
+	var values [statRSS + 1]int64
+	for _, f := range []int{statPgrp, statUtime, statStime, statNumThreads, statRSS} {
+		v, err := strconv.ParseInt(fields[f], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid stat for %d: %v", pid, err)
+		}
+		values[f] = v
+	}
+
+	return &procStat{
+		Pid:     pid,
+		Pgrp:    int(values[statPgrp]),
+		CPU:     time.Duration(values[statUtime]+values[statStime]) * time.Second / clockTicks,
+		Threads: int(values[statNumThreads]),
+		RSS:     uint64(values[statRSS]) * uint64(os.Getpagesize()),
+	}, nil
+}
+
+func countFDs(pid int) int {
+	fds, err := readDirNames(fmt.Sprintf("/proc/%d/fd", pid))
+	if err != nil {
+		return 0
+	}
+	return len(fds)
+}
+
+func readDirNames(path string) ([]string, error) {
+	dir, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	return dir.Readdirnames(-1)
+}
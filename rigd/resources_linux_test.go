@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_ParseProcStat(t *testing.T) {
+	line := "4242 (web (server)) S 1 4242 4242 0 -1 4194560 1000 0 0 0 250 50 0 0 20 0 7 0 100 123456789 512 18446744073709551615"
+	stat, err := parseProcStat(4242, line)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if stat.Pgrp != 4242 {
+		t.Errorf("Expected Pgrp to be 4242, got %d", stat.Pgrp)
+	}
+	if stat.CPU != 3*time.Second {
+		t.Errorf("Expected CPU to be 3s, got %v", stat.CPU)
+	}
+	if stat.Threads != 7 {
+		t.Errorf("Expected Threads to be 7, got %d", stat.Threads)
+	}
+	if stat.RSS != 512*uint64(os.Getpagesize()) {
+		t.Errorf("Expected RSS to be 512 pages, got %d", stat.RSS)
+	}
+}
+
+func Test_ParseProcStatInvalid(t *testing.T) {
+	if _, err := parseProcStat(1, "1 (init) S 0"); err == nil {
+		t.Errorf("Expected an error for a truncated stat")
+	}
+}
+
+func Test_ReadGroupUsage(t *testing.T) {
+	usage, err := readGroupUsage(syscall.Getpgrp())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if usage.RSS == 0 || usage.Threads == 0 || usage.FDs == 0 {
+		t.Errorf("Expected the test's own usage to be found, got %+v", usage)
+	}
+}
+
+func Test_ReadGroupUsages(t *testing.T) {
+	usages, err := readGroupUsages([]int{syscall.Getpgrp(), -1})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if usages[syscall.Getpgrp()] == nil {
+		t.Errorf("Expected the test's own group to be found")
+	}
+	if _, ok := usages[-1]; ok || len(usages) != 1 {
+		t.Errorf("Expected only groups with processes, got %v", usages)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func readGroupUsage(pgid int) (*groupUsage, error) {
+	return nil, fmt.Errorf("Resource sampling isn't supported on %s", runtime.GOOS)
+}
+
+func readGroupUsages(pgids []int) (map[int]*groupUsage, error) {
+	return nil, fmt.Errorf("Resource sampling isn't supported on %s", runtime.GOOS)
+}
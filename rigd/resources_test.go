@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_ResourceHistoryCPU(t *testing.T) {
+	h := NewResourceHistory()
+	start := time.Now()
+	h.Add(&groupUsage{CPU: time.Second}, start)
+	h.Add(&groupUsage{CPU: 2 * time.Second}, start.Add(4*time.Second))
+
+	samples := h.Samples()
+	if len(samples) != 2 {
+		t.Fatalf("Expected 2 samples, got %d", len(samples))
+	}
+	if samples[0].CPU != 0 {
+		t.Errorf("Expected the first sample's CPU to be 0, got %v", samples[0].CPU)
+	}
+	if samples[1].CPU != 25 {
+		t.Errorf("Expected the second sample's CPU to be 25, got %v", samples[1].CPU)
+	}
+}
+
+func Test_ResourceHistoryReset(t *testing.T) {
+	h := NewResourceHistory()
+	start := time.Now()
+	h.Add(&groupUsage{CPU: 10 * time.Second}, start)
+	h.Reset()
+	h.Add(&groupUsage{CPU: time.Second}, start.Add(time.Second))
+
+	if cpu := h.Latest().CPU; cpu != 0 {
+		t.Errorf("Expected CPU to be 0 after a reset, got %v", cpu)
+	}
+}
+
+func Test_ResourceHistoryLength(t *testing.T) {
+	h := NewResourceHistory()
+	start := time.Now()
+	for i := 0; i < resourceHistoryLength+10; i++ {
+		h.Add(&groupUsage{RSS: uint64(i)}, start.Add(time.Duration(i)*time.Second))
+	}
+
+	samples := h.Samples()
+	if len(samples) != resourceHistoryLength {
+		t.Errorf("Expected %d samples, got %d", resourceHistoryLength, len(samples))
+	}
+	if h.Latest().RSS != resourceHistoryLength+9 {
+		t.Errorf("Expected the latest sample to be kept, got %d", h.Latest().RSS)
+	}
+}
@@ -25,18 +25,27 @@ func main() {
 }
 
 func launchServer(configFilename string) {
+	srv := NewServer()
+
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, os.Kill, os.Signal(syscall.SIGTERM))
 	go func() {
 		sig := <-c
-		log.Printf("Received signal %v. Exiting...\n", sig)
+		log.Printf("Received signal %v. Stopping processes...\n", sig)
+		go func() {
+			<-c
+			log.Printf("Exiting without waiting for processes\n")
+			os.Exit(1)
+		}()
+		srv.Shutdown(shutdownTimeout)
+		log.Printf("Exiting...\n")
 		os.Exit(0)
 	}()
 
-	srv := NewServer()
 	if err := srv.LoadConfig(configFilename); err != nil {
 		log.Fatal(err)
 	}
+	go srv.SampleResources(resourceSampleInterval)
 
 	if err := ListenAndServe(defaultAddr, srv); err != nil {
 		log.Fatal(err)
@@ -3,5 +3,6 @@ package main
 type Runnable interface {
 	Start() error
 	Stop() error
+	Restart() error
 }
 
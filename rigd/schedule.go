@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A processScheduler runs a process on its schedule until it's stopped. A run
+// is skipped if the previous one is still going, including its pre_start
+// hook, or if the process was started otherwise.
+type processScheduler struct {
+	sync.Mutex
+	process *Process
+	next    time.Time
+	running bool
+	stopCh  chan bool
+}
+
+func (p *Process) startSchedule() error {
+	s := &processScheduler{process: p, stopCh: make(chan bool)}
+	p.mu.Lock()
+	if p.scheduler != nil {
+		p.mu.Unlock()
+		return fmt.Errorf("Process '%s' is already scheduled", p.Sqd())
+	}
+	p.scheduler = s
+	p.mu.Unlock()
+
+	go s.run()
+	log.Printf("[P] Scheduled process %s\n", p.Sqd())
+	return nil
+}
+
+// stopSchedule stops running the process on its schedule, without stopping
+// a run in progress. It returns whether the process was scheduled.
+func (p *Process) stopSchedule() bool {
+	p.mu.Lock()
+	s := p.scheduler
+	p.scheduler = nil
+	p.mu.Unlock()
+	if s == nil {
+		return false
+	}
+	close(s.stopCh)
+	log.Printf("[P] Unscheduled process %s\n", p.Sqd())
+	return true
+}
+
+func (p *Process) Scheduled() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.scheduler != nil
+}
+
+// NextRun is when the process runs next, if it's scheduled
+func (p *Process) NextRun() (time.Time, bool) {
+	p.mu.Lock()
+	s := p.scheduler
+	p.mu.Unlock()
+	if s == nil {
+		return time.Time{}, false
+	}
+	s.Lock()
+	defer s.Unlock()
+	return s.next, !s.next.IsZero()
+}
+
+func (s *processScheduler) run() {
+	p := s.process
+	for {
+		next := p.schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Printf("[P] %s will never run again\n", p.Sqd())
+			return
+		}
+		s.Lock()
+		s.next = next
+		s.Unlock()
+
+		select {
+		case <-time.After(next.Sub(time.Now())):
+		case <-s.stopCh:
+			return
+		}
+
+		// Set before the run starts, as it only becomes Starting once its
+		// goroutine gets going
+		stopped := p.status() == Stopped
+		s.Lock()
+		skip := s.running || !stopped
+		if !skip {
+			s.running = true
+		}
+		s.Unlock()
+		if skip {
+			log.Printf("[P] Skipping scheduled run of %s: the previous one is still going\n", p.Sqd())
+			continue
+		}
+
+		go func() {
+			p.runOnce()
+			s.Lock()
+			s.running = false
+			s.Unlock()
+		}()
+	}
+}
+
+// carryOverSchedules moves the schedules of processes over to their new
+// instances after a reload, so the old instances stop being run.
+func carryOverSchedules(oldStacks, stacks map[string]*Stack) {
+	for _, s := range oldStacks {
+		for _, svc := range s.Services {
+			for _, old := range svc.Processes {
+				if !old.stopSchedule() {
+					continue
+				}
+				p, err := getProcess(stacks, old.descriptor())
+				if err == nil && p.schedule != nil {
+					p.startSchedule()
+				}
+			}
+		}
+	}
+}
+
+// A Schedule tells when a scheduled process runs next
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+var scheduleAliases = map[string]string{
+	"@yearly":  "0 0 1 1 *",
+	"@monthly": "0 0 1 * *",
+	"@weekly":  "0 0 * * 0",
+	"@daily":   "0 0 * * *",
+	"@hourly":  "0 * * * *",
+}
+
+// ParseSchedule parses a cron expression with five fields (minute, hour,
+// day of month, month and day of week), one of the @hourly style aliases, or
+// "@every <duration>".
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if alias, exists := scheduleAliases[spec]; exists {
+		spec = alias
+	}
+
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("invalid schedule '%s'", spec)
+		}
+		return everySchedule(d), nil
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule '%s': expected 5 fields", spec)
+	}
+
+	s := &cronSchedule{}
+	var err error
+	ranges := []struct {
+		field    *uint64
+		min, max int
+	}{
+		{&s.minute, 0, 59},
+		{&s.hour, 0, 23},
+		{&s.dom, 1, 31},
+		{&s.month, 1, 12},
+		{&s.dow, 0, 7},
+	}
+	for i, r := range ranges {
+		if *r.field, err = parseCronField(fields[i], r.min, r.max); err != nil {
+			return nil, fmt.Errorf("invalid schedule '%s': %v", spec, err)
+		}
+	}
+	// Sunday is 0 or 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	// Like cron, a field starting with a star such as "*/2" counts as one
+	s.domStar = strings.HasPrefix(fields[2], "*")
+	s.dowStar = strings.HasPrefix(fields[4], "*")
+
+	return s, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges (1-5) and
+// steps (*/15, 0-30/10) into a bit set.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in '%s'", part)
+			}
+			step = n
+			part = part[:i]
+		}
+
+		start, end := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			n, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, fmt.Errorf("invalid value '%s'", part)
+			}
+			start, end = n, n
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value '%s'", part)
+				}
+			} else if step > 1 {
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("'%s' is out of range %d-%d", part, min, max)
+		}
+
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+// Next finds the next matching minute, in local time. Like cron, when both
+// the day of month and the day of week are restricted, either can match.
+func (s *cronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	// Every matching time comes around within a few years
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+type everySchedule time.Duration
+
+func (s everySchedule) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(s))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_ScheduleNext(t *testing.T) {
+	// A Wednesday
+	now := time.Date(2014, 5, 14, 10, 17, 30, 0, time.Local)
+
+	tests := map[string]time.Time{
+		"* * * * *":      time.Date(2014, 5, 14, 10, 18, 0, 0, time.Local),
+		"*/15 * * * *":   time.Date(2014, 5, 14, 10, 30, 0, 0, time.Local),
+		"5 * * * *":      time.Date(2014, 5, 14, 11, 5, 0, 0, time.Local),
+		"0 9-17 * * 1-5": time.Date(2014, 5, 14, 11, 0, 0, 0, time.Local),
+		"30 2 * * 0":     time.Date(2014, 5, 18, 2, 30, 0, 0, time.Local),
+		"30 2 * * 7":     time.Date(2014, 5, 18, 2, 30, 0, 0, time.Local),
+		"0 0 1 * *":      time.Date(2014, 6, 1, 0, 0, 0, 0, time.Local),
+		"0 0 29 2 *":     time.Date(2016, 2, 29, 0, 0, 0, 0, time.Local),
+		"0 12 1 * 5":     time.Date(2014, 5, 16, 12, 0, 0, 0, time.Local),
+		"0 0 */2 * 1":    time.Date(2014, 5, 19, 0, 0, 0, 0, time.Local), // Like "*", so an odd Monday
+		"@daily":         time.Date(2014, 5, 15, 0, 0, 0, 0, time.Local),
+		"@every 90s":     now.Add(90 * time.Second),
+	}
+
+	for spec, expected := range tests {
+		s, err := ParseSchedule(spec)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", spec, err)
+			continue
+		}
+		if next := s.Next(now); !next.Equal(expected) {
+			t.Errorf("%s: expected next run at %v, got %v", spec, expected, next)
+		}
+	}
+}
+
+func Test_ParseScheduleInvalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@every 10ms"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("%s: expected an error", spec)
+		}
+	}
+}
+
+func Test_ProcessSchedule(t *testing.T) {
+	svc := &Service{Name: "api", Stack: NewStack("acme"), Config: &ServiceConfig{}}
+	p := NewProcess("reaper", "true", svc)
+	p.schedule = everySchedule(time.Hour)
+
+	if err := p.Start(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := p.Start(); err == nil {
+		t.Errorf("Expected an error scheduling the process twice")
+	}
+
+	// The scheduler sets the next run in the background
+	var next time.Time
+	for i := 0; i < 100; i++ {
+		if n, ok := p.NextRun(); ok {
+			next = n
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if d := next.Sub(time.Now()); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("Expected the next run in an hour, got %v", next)
+	}
+
+	if err := p.Stop(); err != nil {
+		t.Errorf("Expected stopping a scheduled process not to fail, got %v", err)
+	}
+	if p.Scheduled() {
+		t.Errorf("Expected the process not to be scheduled anymore")
+	}
+	if _, ok := p.NextRun(); ok {
+		t.Errorf("Expected no next run")
+	}
+}
+
+func Test_ScheduledRunsDontOverlap(t *testing.T) {
+	svc := &Service{Name: "api", Stack: NewStack("acme"), Config: &ServiceConfig{}}
+	p := NewProcess("reaper", "true", svc)
+	p.hooks = &HooksConfig{PreStart: "sleep 1"}
+	p.schedule = everySchedule(20 * time.Millisecond)
+
+	if err := p.Start(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// Many runs are due while the first one is in its pre_start hook
+	time.Sleep(500 * time.Millisecond)
+	p.Stop()
+
+	for i := 0; i < 100 && len(p.runs.Runs()) == 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if runs := p.runs.Runs(); len(runs) != 1 {
+		t.Errorf("Expected a single run, got %+v", runs)
+	}
+}
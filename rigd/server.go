@@ -4,16 +4,25 @@ import (
 	"fmt"
 	"github.com/gocardless/rig"
 	"log"
+	"path/filepath"
+	"syscall"
 )
 
 type Server struct {
-	Config *Config
-	Stacks map[string]*Stack
+	Config         *Config
+	Stacks         map[string]*Stack
+	logStore       *LogStore
+	sinks          []*SinkRunner
+	requestMetrics *RequestMetrics
+	proxy          *Proxy
+	dns            *DNSServer
 }
 
 func NewServer() *Server {
 	return &Server{
-		Stacks: map[string]*Stack{},
+		Stacks:         map[string]*Stack{},
+		logStore:       NewLogStore(),
+		requestMetrics: NewRequestMetrics(),
 	}
 }
 
@@ -22,24 +31,142 @@ func (srv *Server) LoadConfig(configFilename string) error {
 	if err != nil {
 		return err
 	}
-	srv.Config = config
 
-	for name, config := range srv.Config.Stacks {
+	stacks := map[string]*Stack{}
+	for name, stackConfig := range config.Stacks {
 		stack := NewStack(name)
-		if err := loadServices(stack, config); err != nil {
+		if err := loadServices(stack, config, stackConfig); err != nil {
 			return err
 		}
-		srv.Stacks[name] = stack
+		stacks[name] = stack
+	}
+	carryOverOutput(srv.Stacks, stacks)
+	carryOverSchedules(srv.Stacks, stacks)
+	carryOverWatches(srv.Stacks, stacks)
+	carryOverLazy(srv.Stacks, stacks)
+
+	srv.Config = config
+	srv.Stacks = stacks
+
+	if err := srv.logStore.Configure(config); err != nil {
+		return err
 	}
+	srv.logStore.Attach(srv.allProcesses())
+
+	srv.loadSinks()
+	srv.loadProxy()
+	srv.loadDNS()
 	return nil
 }
 
-func loadServices(stack *Stack, stackConfig *StackConfig) error {
+// loadProxy starts the proxy, or restarts it when its config changed. Like
+// sinks, a proxy which can't be created is logged.
+func (srv *Server) loadProxy() {
+	config := srv.Config.Proxy
+	if srv.proxy != nil {
+		if config != nil && *config == srv.proxy.config {
+			return
+		}
+		srv.proxy.Close()
+		srv.proxy = nil
+	}
+	if config == nil {
+		return
+	}
+
+	proxy, err := NewProxy(srv, config, filepath.Dir(srv.Config.Filename))
+	if err != nil {
+		log.Printf("[X] Error creating the proxy: %v\n", err)
+		return
+	}
+	if !proxy.Start() {
+		log.Printf("[X] Not proxying, as the proxy can't listen on any address\n")
+		proxy.Close()
+		return
+	}
+	srv.proxy = proxy
+}
+
+// loadDNS starts the DNS server, or restarts it when its config changed, and
+// updates its hosts.
+func (srv *Server) loadDNS() {
+	config := srv.Config.DNS
+	var dns *DNSServer
+	if config != nil {
+		domain := defaultProxyDomain
+		if srv.proxy != nil {
+			domain = srv.proxy.domain
+		}
+		dns = NewDNSServer(config, domain)
+	}
+
+	if srv.dns != nil && (dns == nil || dns.config != srv.dns.config || dns.Domain != srv.dns.Domain) {
+		srv.dns.Close()
+		srv.dns = nil
+	}
+	if dns == nil {
+		return
+	}
+
+	if srv.dns == nil {
+		if err := dns.Start(); err != nil {
+			log.Printf("[D] Error starting the DNS server: %v\n", err)
+			return
+		}
+		srv.dns = dns
+	}
+	srv.dns.SetHosts(srv.Stacks)
+}
+
+// URL is where a process, or the web process of a service, can be reached:
+// through the proxy if it's running, or on localhost.
+func (srv *Server) URL(d *rig.Descriptor) (string, error) {
+	if d.Process == "" {
+		d = &rig.Descriptor{Stack: d.Stack, Service: d.Service, Process: "web"}
+	}
+	p, err := srv.GetProcess(d)
+	if err != nil {
+		return "", err
+	}
+	if p.Port() == 0 {
+		return "", fmt.Errorf("%s has no port", p.Fqd())
+	}
+
+	if srv.proxy != nil {
+		return srv.proxy.URL(p), nil
+	}
+	return fmt.Sprintf("http://localhost:%d", p.Port()), nil
+}
+
+// loadSinks replaces the running sinks with the ones in the config. A sink
+// which can't be created is logged and skipped, so it doesn't prevent rigd
+// from starting.
+func (srv *Server) loadSinks() {
+	for _, r := range srv.sinks {
+		r.Close()
+	}
+	srv.sinks = nil
+
+	for _, config := range srv.Config.Sinks {
+		r, err := NewSinkRunner(config)
+		if err != nil {
+			log.Printf("[O] Error creating %s sink: %v\n", config.Type, err)
+			continue
+		}
+		r.Attach(srv.allProcesses())
+		srv.sinks = append(srv.sinks, r)
+	}
+}
+
+func loadServices(stack *Stack, global *Config, stackConfig *StackConfig) error {
 	for name, config := range stackConfig.Services {
 		service, err := NewService(name, config.Dir, stack)
 		if err != nil {
 			return err
 		}
+		if err := service.Configure(global, config); err != nil {
+			return err
+		}
 		stack.Services[name] = service
 	}
 	return nil
@@ -68,8 +195,26 @@ func (srv *Server) GetService(d *rig.Descriptor) (*Service, error) {
 	return svc, nil
 }
 
+func (srv *Server) GetTask(d *rig.Descriptor, name string) (*Task, error) {
+	svc, err := srv.GetService(d)
+	if err != nil {
+		return nil, err
+	}
+
+	t := svc.Tasks[name]
+	if t == nil {
+		return nil, fmt.Errorf("task '%v' does not exist", name)
+	}
+
+	return t, nil
+}
+
 func (srv *Server) GetProcess(d *rig.Descriptor) (*Process, error) {
-	s := srv.Stacks[d.Stack]
+	return getProcess(srv.Stacks, d)
+}
+
+func getProcess(stacks map[string]*Stack, d *rig.Descriptor) (*Process, error) {
+	s := stacks[d.Stack]
 	if s == nil {
 		return nil, fmt.Errorf("stack '%v' does not exist", d.Stack)
 	}
@@ -109,16 +254,36 @@ func (srv *Server) StopStack(d *rig.Descriptor) error {
 	return nil
 }
 
-func (srv *Server) TailStack(d *rig.Descriptor, c chan rig.ProcessOutputMessage, num int) error {
+func (srv *Server) RestartStack(d *rig.Descriptor) error {
 	s, err := srv.GetStack(d)
 	if err != nil {
 		return err
 	}
 
-	s.SubscribeToOutput(c, num)
+	go s.Restart()
+
 	return nil
 }
 
+func (srv *Server) TailStack(d *rig.Descriptor, c chan rig.ProcessOutputMessage, num int, filter *LogFilter) ([]*rig.ProcessOutputMessage, []*ProcessOutputSubscription, error) {
+	s, err := srv.GetStack(d)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	history, subs := s.SubscribeToOutput(c, num, filter)
+	return history, subs, nil
+}
+
+func (srv *Server) HistoryStack(d *rig.Descriptor, num int, filter *LogFilter) ([]*rig.ProcessOutputMessage, error) {
+	s, err := srv.GetStack(d)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.History(num, filter), nil
+}
+
 func (srv *Server) StartService(d *rig.Descriptor) error {
 	svc, err := srv.GetService(d)
 	if err != nil {
@@ -141,16 +306,36 @@ func (srv *Server) StopService(d *rig.Descriptor) error {
 	return nil
 }
 
-func (srv *Server) TailService(d *rig.Descriptor, c chan rig.ProcessOutputMessage, num int) error {
+func (srv *Server) RestartService(d *rig.Descriptor) error {
 	svc, err := srv.GetService(d)
 	if err != nil {
 		return err
 	}
 
-	svc.SubscribeToOutput(c, num)
+	go svc.Restart()
+
 	return nil
 }
 
+func (srv *Server) TailService(d *rig.Descriptor, c chan rig.ProcessOutputMessage, num int, filter *LogFilter) ([]*rig.ProcessOutputMessage, []*ProcessOutputSubscription, error) {
+	svc, err := srv.GetService(d)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	history, subs := svc.SubscribeToOutput(c, num, filter)
+	return history, subs, nil
+}
+
+func (srv *Server) HistoryService(d *rig.Descriptor, num int, filter *LogFilter) ([]*rig.ProcessOutputMessage, error) {
+	svc, err := srv.GetService(d)
+	if err != nil {
+		return nil, err
+	}
+
+	return svc.History(num, filter), nil
+}
+
 func (srv *Server) StartProcess(d *rig.Descriptor) error {
 	p, err := srv.GetProcess(d)
 	if err != nil {
@@ -171,16 +356,60 @@ func (srv *Server) StopProcess(d *rig.Descriptor) error {
 	return p.Stop()
 }
 
-func (srv *Server) TailProcess(d *rig.Descriptor, c chan rig.ProcessOutputMessage, num int) error {
+func (srv *Server) RestartProcess(d *rig.Descriptor) error {
 	p, err := srv.GetProcess(d)
 	if err != nil {
 		return err
 	}
 
-	p.SubscribeToOutput(c, num)
+	go p.Restart()
+
 	return nil
 }
 
+func (srv *Server) TailProcess(d *rig.Descriptor, c chan rig.ProcessOutputMessage, num int, filter *LogFilter) ([]*rig.ProcessOutputMessage, []*ProcessOutputSubscription, error) {
+	p, err := srv.GetProcess(d)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	history, subs := p.SubscribeToOutput(c, num, filter)
+	return history, subs, nil
+}
+
+func (srv *Server) HistoryProcess(d *rig.Descriptor, num int, filter *LogFilter) ([]*rig.ProcessOutputMessage, error) {
+	p, err := srv.GetProcess(d)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.History(num, filter), nil
+}
+
+// Command builds a one-off command like the processes of a service are run.
+// Its Env is only what rig adds to the environment, which the caller merges
+// into its own: for a process, its $PORT; for a service, nothing.
+func (srv *Server) Command(d *rig.Descriptor, command string) (*rig.ApiCommand, error) {
+	if command == "" {
+		return nil, fmt.Errorf("Bad parameter: missing command")
+	}
+
+	svc, err := srv.GetService(d)
+	if err != nil {
+		return nil, err
+	}
+
+	p := NewProcess("run", command, svc)
+	if d.Process != "" {
+		if p, err = srv.GetProcess(d); err != nil {
+			return nil, err
+		}
+	}
+
+	cmd := p.shellCommand(command)
+	return &rig.ApiCommand{Dir: cmd.Dir, Args: cmd.Args, Env: p.addedEnv()}, nil
+}
+
 func (srv *Server) Resolve(str, pwd string) (*rig.Descriptor, error) {
 	res := NewResolver(srv.Stacks, str, pwd)
 
@@ -192,13 +421,197 @@ func (srv *Server) Resolve(str, pwd string) (*rig.Descriptor, error) {
 	return d, err
 }
 
+// ResolveAll resolves a descriptor which can be a glob to every descriptor
+// it matches
+func (srv *Server) ResolveAll(str, pwd string) ([]*rig.Descriptor, error) {
+	return NewResolver(srv.Stacks, str, pwd).GetDescriptors()
+}
+
+// matchingProcesses returns the processes of every stack, service or process
+// a glob descriptor matches, each once
+func (srv *Server) matchingProcesses(d *rig.Descriptor) ([]*Process, error) {
+	ds, err := srv.ResolveAll(descriptorString(d), "")
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[*Process]bool{}
+	var processes []*Process
+	for _, d := range ds {
+		ps, err := srv.Processes(d)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range ps {
+			if !seen[p] {
+				seen[p] = true
+				processes = append(processes, p)
+			}
+		}
+	}
+	return processes, nil
+}
+
+// TailMatching subscribes to the output of every process a glob descriptor
+// matches, and returns their merged history
+func (srv *Server) TailMatching(d *rig.Descriptor, c chan rig.ProcessOutputMessage, num int, filter *LogFilter) ([]*rig.ProcessOutputMessage, []*ProcessOutputSubscription, error) {
+	processes, err := srv.matchingProcesses(d)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	history, subs := subscribeToOutput(processes, c, num, filter)
+	return history, subs, nil
+}
+
+// HistoryMatching returns the merged history of every process a glob
+// descriptor matches
+func (srv *Server) HistoryMatching(d *rig.Descriptor, num int, filter *LogFilter) ([]*rig.ProcessOutputMessage, error) {
+	processes, err := srv.matchingProcesses(d)
+	if err != nil {
+		return nil, err
+	}
+
+	return historyOf(processes, num, filter), nil
+}
+
 func (srv *Server) ReloadConfig() error {
 	log.Printf("Reloading config...\n")
-	srv.Stacks = map[string]*Stack{}
-	if err := srv.LoadConfig(srv.Config.Filename); err != nil {
-		return err
+	return srv.LoadConfig(srv.Config.Filename)
+}
+
+// carryOverOutput hands the output buffers and dispatchers of processes which
+// survived a reload over to their new instances, so the most recent output
+// isn't lost and existing subscribers keep receiving it. Buffers are resized
+// to the new limits. Resource samples, counters and task runs are carried
+// over too.
+func carryOverOutput(oldStacks, stacks map[string]*Stack) {
+	for _, s := range stacks {
+		for _, svc := range s.Services {
+			if old := oldStacks[s.Name]; old != nil && old.Services[svc.Name] != nil {
+				for name, t := range svc.Tasks {
+					if oldTask := old.Services[svc.Name].Tasks[name]; oldTask != nil {
+						t.process.runs = oldTask.process.runs
+					}
+				}
+			}
+			for _, p := range svc.Processes {
+				old, err := getProcess(oldStacks, p.descriptor())
+				if err != nil {
+					continue
+				}
+				old.buffer.Resize(p.buffer.Limits())
+				p.buffer = old.buffer
+				p.outputDispatcher = old.outputDispatcher
+				p.resources = old.resources
+				p.stats = old.stats
+				p.runs = old.runs
+			}
+		}
 	}
-	return nil
+
+	// Processes which were removed won't publish anything anymore
+	for _, s := range oldStacks {
+		for _, svc := range s.Services {
+			for _, old := range svc.Processes {
+				if _, err := getProcess(stacks, old.descriptor()); err != nil {
+					old.outputDispatcher.End()
+				}
+			}
+		}
+	}
+}
+
+func (srv *Server) allProcesses() []*Process {
+	var processes []*Process
+	for _, s := range srv.Stacks {
+		for _, svc := range s.Services {
+			for _, p := range svc.Processes {
+				processes = append(processes, p)
+			}
+		}
+	}
+	return processes
+}
+
+// Processes returns the processes a descriptor refers to. An empty
+// descriptor refers to every process.
+func (srv *Server) Processes(d *rig.Descriptor) ([]*Process, error) {
+	if d.Stack == "" {
+		return srv.allProcesses(), nil
+	}
+
+	if d.Process != "" {
+		p, err := srv.GetProcess(d)
+		if err != nil {
+			return nil, err
+		}
+		return []*Process{p}, nil
+	}
+
+	var processes []*Process
+	if d.Service != "" {
+		svc, err := srv.GetService(d)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range svc.Processes {
+			processes = append(processes, p)
+		}
+		return processes, nil
+	}
+
+	s, err := srv.GetStack(d)
+	if err != nil {
+		return nil, err
+	}
+	for _, svc := range s.Services {
+		for _, p := range svc.Processes {
+			processes = append(processes, p)
+		}
+	}
+	return processes, nil
+}
+
+// Signal sends a signal to the running processes a descriptor refers to, or
+// to their process groups, and returns the ones it was sent to.
+func (srv *Server) Signal(d *rig.Descriptor, sig syscall.Signal, group bool) ([]string, error) {
+	processes, err := srv.Processes(d)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Process != "" {
+		if err := processes[0].Signal(sig, group); err != nil {
+			return nil, err
+		}
+		return []string{processes[0].Sqd()}, nil
+	}
+
+	signalled := []string{}
+	for _, p := range processes {
+		if p.status() != Running {
+			continue
+		}
+		if err := p.Signal(sig, group); err != nil {
+			log.Printf("[P] Error signalling %s: %v\n", p.Sqd(), err)
+			continue
+		}
+		signalled = append(signalled, p.Sqd())
+	}
+	if len(signalled) == 0 {
+		return nil, fmt.Errorf("Can't signal: no process is running")
+	}
+	return signalled, nil
+}
+
+func (srv *Server) Search(d *rig.Descriptor, query *SearchQuery) ([]*rig.ApiSearchResult, error) {
+	processes, err := srv.Processes(d)
+	if err != nil {
+		return nil, err
+	}
+
+	return srv.logStore.Search(processes, query)
 }
 
 func (srv *Server) Version() rig.ApiVersion {
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"github.com/gocardless/rig"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Command(t *testing.T) {
+	srv := NewServer()
+	stack := NewStack("acme")
+	svc := &Service{Name: "api", Dir: "/src/api", Stack: stack, Config: &ServiceConfig{Port: 5000}, Processes: map[string]*Process{}}
+	svc.Processes["web"] = NewProcess("web", "rails server", svc)
+	stack.Services["api"] = svc
+	srv.Stacks["acme"] = stack
+
+	command, err := srv.Command(&rig.Descriptor{Stack: "acme", Service: "api", Process: "web"}, "rails console")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if command.Dir != "/src/api" {
+		t.Errorf("Expected the command to run in the service's dir, got %s", command.Dir)
+	}
+	if command.Args[len(command.Args)-1] != "rails console" {
+		t.Errorf("Expected the command to be run by the shell, got %v", command.Args)
+	}
+	if !hasEnv(command.Env, "PORT=5000") {
+		t.Errorf("Expected the web process's port")
+	}
+	if len(command.Env) != 1 {
+		t.Errorf("Expected only the variables rig adds, got %v", command.Env)
+	}
+
+	command, err = srv.Command(&rig.Descriptor{Stack: "acme", Service: "api"}, "rails console")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if hasEnv(command.Env, "PORT=5000") {
+		t.Errorf("Expected no port for a command run in a service")
+	}
+
+	if _, err := srv.Command(&rig.Descriptor{Stack: "acme", Service: "api"}, ""); err == nil {
+		t.Errorf("Expected an error without a command")
+	}
+}
+
+func Test_GlobDescriptors(t *testing.T) {
+	srv := NewServer()
+	start := time.Now()
+	for _, name := range []string{"acme", "blog"} {
+		stack := NewStack(name)
+		for _, svcName := range []string{"api", "website"} {
+			svc := &Service{Name: svcName, Stack: stack, Config: &ServiceConfig{}, Processes: map[string]*Process{}}
+			for i, pName := range []string{"web", "worker"} {
+				p := NewProcess(pName, "true", svc)
+				p.buffer.Append(rig.ProcessOutputMessage{Content: name + ":" + svcName + ":" + pName, Time: start.Add(time.Duration(i))})
+				svc.Processes[pName] = p
+			}
+			stack.Services[svcName] = svc
+		}
+		srv.Stacks[name] = stack
+	}
+
+	var matched []string
+	vars := map[string]string{"stack": "acme", "service": "*", "process": "worker"}
+	err := forEachDescriptor(srv, vars, func(d *rig.Descriptor) error {
+		matched = append(matched, descriptorString(d))
+		return nil
+	})
+	if err != nil || len(matched) != 2 || matched[0] != "acme:api:worker" || matched[1] != "acme:website:worker" {
+		t.Errorf("Expected both of acme's workers, got %v (%v)", matched, err)
+	}
+
+	vars = map[string]string{"stack": "acme", "service": "*", "process": "nope"}
+	if err := forEachDescriptor(srv, vars, func(d *rig.Descriptor) error { return nil }); err == nil {
+		t.Errorf("Expected an error when a glob matches nothing")
+	}
+
+	history, err := srv.HistoryMatching(&rig.Descriptor{Stack: "*", Service: "api"}, 10, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(history) != 4 {
+		t.Errorf("Expected the output of both stacks' api processes, got %d lines", len(history))
+	}
+	for _, msg := range history {
+		if !strings.Contains(msg.Content, ":api:") {
+			t.Errorf("Expected only api output, got %s", msg.Content)
+		}
+	}
+
+	c := make(chan rig.ProcessOutputMessage, 4)
+	history, subs, err := srv.TailMatching(&rig.Descriptor{Stack: "{acme,blog}", Service: "website", Process: "web"}, c, 4, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(subs) != 2 || len(history) != 2 {
+		t.Errorf("Expected two subscriptions and two lines of history, got %d and %d", len(subs), len(history))
+	}
+}
+
+func hasEnv(env []string, value string) bool {
+	for _, v := range env {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"github.com/gocardless/rig"
 	"log"
@@ -16,7 +17,9 @@ type Service struct {
 	Name      string
 	Dir       string
 	Stack     *Stack
+	Config    *ServiceConfig
 	Processes map[string]*Process
+	Tasks     map[string]*Task
 }
 
 func NewService(name string, dir string, stack *Stack) (*Service, error) {
@@ -24,7 +27,9 @@ func NewService(name string, dir string, stack *Stack) (*Service, error) {
 		Name:      name,
 		Dir:       dir,
 		Stack:     stack,
+		Config:    &ServiceConfig{Dir: dir},
 		Processes: make(map[string]*Process),
+		Tasks:     make(map[string]*Task),
 	}
 
 	if err := s.parseProcfile(path.Join(dir, "Procfile")); err != nil {
@@ -33,7 +38,122 @@ func NewService(name string, dir string, stack *Stack) (*Service, error) {
 	return s, nil
 }
 
+// Configure applies the service's config, and the global settings it
+// inherits, to the service and its processes.
+func (s *Service) Configure(global *Config, config *ServiceConfig) error {
+	s.Config = config
+
+	for name, cmd := range config.Tasks {
+		if _, exists := s.Processes[name]; exists {
+			return fmt.Errorf("[S] Error in config of %s: task %s has the name of a process", s.Name, name)
+		}
+		s.Tasks[name] = NewTask(name, cmd, s)
+	}
+	for _, name := range config.StartTasks {
+		if s.Tasks[name] == nil {
+			return fmt.Errorf("[S] Error in config of %s: unknown start task %s", s.Name, name)
+		}
+	}
+
+	for name, p := range s.Processes {
+		if pc, exists := config.Processes[name]; exists && pc != nil {
+			p.Config = pc
+		}
+		p.buffer.Resize(logBufferLimits(p.Config.LogBuffer, config.LogBuffer, global.LogBuffer))
+
+		if !isLogFormat(p.Config.LogFormat) {
+			return fmt.Errorf("[S] Error in config of %s: unknown log_format '%s', expected auto, json or text", p.Sqd(), p.Config.LogFormat)
+		}
+
+		multiline := p.Config.Multiline
+		if multiline == nil {
+			multiline = config.Multiline
+		}
+		if multiline != nil {
+			rules, err := NewMultilineRules(multiline)
+			if err != nil {
+				return fmt.Errorf("[S] Error in config of %s: %v", p.Sqd(), err)
+			}
+			p.multiline = rules
+		}
+
+		limits := p.Config.Limits
+		if limits == nil {
+			limits = config.Limits
+		}
+		if limits != nil {
+			l, err := NewProcessLimits(limits)
+			if err != nil {
+				return fmt.Errorf("[S] Error in config of %s: %v", p.Sqd(), err)
+			}
+			p.limits = l
+		}
+
+		p.hooks = mergeHooks(p.Config.Hooks, config.Hooks)
+		timeout, err := hookTimeout(p.hooks)
+		if err != nil {
+			return fmt.Errorf("[S] Error in config of %s: %v", p.Sqd(), err)
+		}
+		p.hookTimeout = timeout
+
+		if p.Config.Schedule != "" {
+			schedule, err := ParseSchedule(p.Config.Schedule)
+			if err != nil {
+				return fmt.Errorf("[S] Error in config of %s: %v", p.Sqd(), err)
+			}
+			p.schedule = schedule
+		}
+
+		if p.Config.Watch != nil {
+			rules, err := NewWatchRules(p.Config.Watch)
+			if err != nil {
+				return fmt.Errorf("[S] Error in config of %s: %v", p.Sqd(), err)
+			}
+			p.watch = rules
+		}
+
+		// The service's setting only applies to the processes it can
+		lazy := p.Config.Lazy
+		if lazy == nil && p.Port() != 0 && p.schedule == nil {
+			lazy = config.Lazy
+		}
+		if lazy != nil {
+			if p.Port() == 0 {
+				return fmt.Errorf("[S] Error in config of %s: lazy processes need a port", p.Sqd())
+			}
+			if p.schedule != nil {
+				return fmt.Errorf("[S] Error in config of %s: scheduled processes can't be lazy", p.Sqd())
+			}
+			rules, err := NewLazyRules(lazy)
+			if err != nil {
+				return fmt.Errorf("[S] Error in config of %s: %v", p.Sqd(), err)
+			}
+			p.lazy = rules
+		}
+
+		if p.Config.TTY {
+			size, err := ParseTTYSize(p.Config.TTYSize)
+			if err != nil {
+				return fmt.Errorf("[S] Error in config of %s: %v", p.Sqd(), err)
+			}
+			p.tty = size
+		}
+	}
+	return nil
+}
+
 func (s *Service) Start() error {
+	for _, name := range s.Config.StartTasks {
+		run, err := s.Tasks[name].Run()
+		if err == nil && run.Error != "" {
+			err = errors.New(run.Error)
+		}
+		if err != nil {
+			log.Printf("[S] Not starting %s: start task %s failed\n", s.Name, name)
+			return err
+		}
+	}
+
 	var wg sync.WaitGroup
 	for _, p := range s.Processes {
 		wg.Add(1)
@@ -57,20 +177,35 @@ func (s *Service) Stop() error {
 	return nil
 }
 
-func (s *Service) SubscribeToOutput(c chan rig.ProcessOutputMessage, num int) {
-	var buffers []*ring.Ring
+func (s *Service) Restart() error {
+	var wg sync.WaitGroup
 	for _, p := range s.Processes {
-		buffers = append(buffers, p.buffer)
+		wg.Add(1)
+		go func(p *Process) {
+			if err := p.Restart(); err != nil {
+				log.Printf("[S] %v\n", err)
+			}
+			wg.Done()
+		}(p)
 	}
+	wg.Wait()
+	return nil
+}
 
-	tailBuffer := MultiTail(buffers, num)
-	for _, msg := range tailBuffer {
-		c <- *msg
+func (s *Service) History(num int, filter *LogFilter) []*rig.ProcessOutputMessage {
+	var buffers []*ring.Ring
+	for _, p := range s.Processes {
+		buffers = append(buffers, p.buffer.Ring())
 	}
+	return filter.Tail(buffers, num)
+}
 
+func (s *Service) SubscribeToOutput(c chan rig.ProcessOutputMessage, num int, filter *LogFilter) ([]*rig.ProcessOutputMessage, []*ProcessOutputSubscription) {
+	var processes []*Process
 	for _, p := range s.Processes {
-		p.outputDispatcher.Subscribe(c)
+		processes = append(processes, p)
 	}
+	return subscribeToOutput(processes, c, num, filter)
 }
 
 func (s *Service) parseProcfile(path string) error {
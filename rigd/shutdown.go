@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// How long rigd waits on shutdown for processes to exit after SIGTERM,
+// before sending SIGKILL
+const shutdownTimeout = 10 * time.Second
+
+// Shutdown stops every process and task and waits for them to exit, so that
+// none outlives rigd holding on to its port.
+func (srv *Server) Shutdown(timeout time.Duration) {
+	var processes []*Process
+	for _, s := range srv.Stacks {
+		for _, svc := range s.Services {
+			for _, p := range svc.Processes {
+				processes = append(processes, p)
+			}
+			for _, t := range svc.Tasks {
+				processes = append(processes, t.process)
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, p := range processes {
+		wg.Add(1)
+		go func(p *Process) {
+			p.shutdown(timeout)
+			wg.Done()
+		}(p)
+	}
+	wg.Wait()
+}
+
+// shutdown stops the process for good. Its whole process group is sent
+// SIGTERM, then SIGKILL if it's still running after the timeout.
+func (p *Process) shutdown(timeout time.Duration) {
+	p.stopSchedule()
+	p.stopWatch()
+	p.stopLazy()
+	p.abortStart()
+
+	st := p.state()
+	if st.status != Running {
+		return
+	}
+	if err := p.runHook(HookPreStop, p.hooks.PreStop); err != nil {
+		log.Printf("[P] %s hook of %s failed: %v\n", HookPreStop, p.Sqd(), err)
+	}
+	p.Signal(syscall.SIGTERM, true)
+	select {
+	case <-st.done:
+		return
+	case <-time.After(timeout):
+	}
+
+	log.Printf("[P] %s didn't exit within %v\n", p.Sqd(), timeout)
+	p.Signal(syscall.SIGKILL, true)
+	select {
+	case <-st.done:
+	case <-time.After(time.Second):
+		log.Printf("[P] %s is still running\n", p.Sqd())
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ServerShutdown(t *testing.T) {
+	srv := NewServer()
+	stack := NewStack("acme")
+	svc := &Service{Name: "api", Stack: stack, Config: &ServiceConfig{}, Processes: map[string]*Process{}, Tasks: map[string]*Task{}}
+	svc.Processes["web"] = NewProcess("web", "sleep 30 & wait", svc)
+	// sleep inherits the ignored SIGTERM, so only SIGKILL stops it
+	worker := NewProcess("worker", "trap '' TERM; sleep 30 & echo sleeping $!; wait", svc)
+	svc.Processes["worker"] = worker
+	stack.Services["api"] = svc
+	srv.Stacks["acme"] = stack
+
+	for _, p := range svc.Processes {
+		go p.Start()
+	}
+	// Login shells can be slow to start
+	sleepPid := 0
+	for deadline := time.Now().Add(30 * time.Second); sleepPid == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		for _, msg := range worker.History(100, nil) {
+			if strings.HasPrefix(msg.Content, "sleeping ") {
+				sleepPid, _ = strconv.Atoi(strings.TrimPrefix(msg.Content, "sleeping "))
+			}
+		}
+	}
+	if sleepPid == 0 {
+		t.Fatalf("Expected the worker to start sleeping")
+	}
+
+	done := make(chan bool)
+	go func() {
+		srv.Shutdown(500 * time.Millisecond)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected shutting down not to take much longer than the timeout")
+	}
+
+	for name, p := range svc.Processes {
+		if p.status() != Stopped {
+			t.Errorf("Expected %s to be stopped", name)
+		}
+	}
+	// The kernel can take a moment to tear the group down after the worker
+	// itself is reaped
+	for deadline := time.Now().Add(5 * time.Second); processAlive(sleepPid); {
+		if time.Now().After(deadline) {
+			t.Errorf("Expected the worker's child to be killed")
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// processAlive returns whether pid is running, rather than gone or a zombie
+func processAlive(pid int) bool {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(b)[strings.LastIndex(string(b), ")")+1:])
+	return len(fields) > 0 && fields[0] != "Z"
+}
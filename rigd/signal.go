@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var signals = map[string]syscall.Signal{
+	"HUP":    syscall.SIGHUP,
+	"INT":    syscall.SIGINT,
+	"QUIT":   syscall.SIGQUIT,
+	"KILL":   syscall.SIGKILL,
+	"USR1":   syscall.SIGUSR1,
+	"USR2":   syscall.SIGUSR2,
+	"ALRM":   syscall.SIGALRM,
+	"TERM":   syscall.SIGTERM,
+	"CONT":   syscall.SIGCONT,
+	"STOP":   syscall.SIGSTOP,
+	"TSTP":   syscall.SIGTSTP,
+	"TTIN":   syscall.SIGTTIN,
+	"TTOU":   syscall.SIGTTOU,
+	"WINCH":  syscall.SIGWINCH,
+	"PROF":   syscall.SIGPROF,
+	"VTALRM": syscall.SIGVTALRM,
+}
+
+// ParseSignal parses a signal name such as "HUP" or "SIGUSR2", in any case,
+// or a signal number.
+func ParseSignal(name string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(name); err == nil && n > 0 {
+		return syscall.Signal(n), nil
+	}
+	if sig, exists := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]; exists {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unknown signal '%s'", name)
+}
+
+// SignalName is the name of a signal without its SIG prefix, or its number
+func SignalName(sig syscall.Signal) string {
+	for name, s := range signals {
+		if s == sig {
+			return name
+		}
+	}
+	return strconv.Itoa(int(sig))
+}
+
+// Signal sends a signal to the process, or to its whole process group, which
+// includes the children it didn't put in groups of their own.
+func (p *Process) Signal(sig syscall.Signal, group bool) error {
+	proc, ok := p.running()
+	if !ok {
+		return fmt.Errorf("Can't signal: %s isn't running", p.Sqd())
+	}
+	log.Printf("[P] Sending SIG%s to %s\n", SignalName(sig), p.Sqd())
+	if group {
+		return syscall.Kill(-proc.Pid, sig)
+	}
+	return proc.Signal(sig)
+}
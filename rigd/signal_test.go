@@ -0,0 +1,62 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_ParseSignal(t *testing.T) {
+	tests := map[string]syscall.Signal{
+		"HUP":     syscall.SIGHUP,
+		"usr1":    syscall.SIGUSR1,
+		"SIGUSR2": syscall.SIGUSR2,
+		"sigttin": syscall.SIGTTIN,
+		"9":       syscall.SIGKILL,
+	}
+	for name, expected := range tests {
+		sig, err := ParseSignal(name)
+		if err != nil || sig != expected {
+			t.Errorf("Expected %s to be %v, got %v (%v)", name, expected, sig, err)
+		}
+	}
+
+	for _, name := range []string{"", "NOPE", "-1", "0"} {
+		if _, err := ParseSignal(name); err == nil {
+			t.Errorf("Expected an error for '%s'", name)
+		}
+	}
+
+	if name := SignalName(syscall.SIGUSR1); name != "USR1" {
+		t.Errorf("Expected USR1, got %s", name)
+	}
+}
+
+func Test_ProcessSignalGroup(t *testing.T) {
+	svc := &Service{Name: "api", Stack: NewStack("acme"), Config: &ServiceConfig{}}
+	p := NewProcess("worker", "sleep 30 & wait", svc)
+
+	if err := p.Signal(syscall.SIGTERM, true); err == nil {
+		t.Errorf("Expected an error signalling a stopped process")
+	}
+
+	exited := make(chan bool)
+	go func() {
+		p.Start()
+		close(exited)
+	}()
+	for i := 0; i < 200 && p.status() != Running; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// sleep holds on to the output pipes, so the process only exits quickly
+	// when its whole group is signalled
+	if err := p.Signal(syscall.SIGTERM, true); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected the process group to exit")
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gocardless/rig"
+	"github.com/gocardless/rig/utils"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	sinkBufferSize = 1000
+	sinkBatchSize  = 100
+	// How long a write to a sink can take, so a stuck collector can't hold
+	// up the others forever
+	sinkWriteTimeout = 10 * time.Second
+)
+
+// A Sink forwards process output somewhere outside of rig. Messages are
+// written in batches of whatever was waiting to be sent.
+type Sink interface {
+	Write(msgs []rig.ProcessOutputMessage) error
+	Close() error
+}
+
+func NewSink(config *SinkConfig) (Sink, error) {
+	switch config.Type {
+	case "syslog":
+		return newSyslogSink(config.Network, config.Address)
+	case "file":
+		return newFileSink(config.Path)
+	case "tcp":
+		return newTCPSink(config.Address)
+	case "http":
+		return newHTTPSink(config.URL)
+	}
+	return nil, fmt.Errorf("Unknown sink type '%s'", config.Type)
+}
+
+// SinkRunner feeds a sink with the output of the processes it is attached to.
+// It never blocks the processes: if the sink can't keep up, messages are
+// dropped.
+type SinkRunner struct {
+	sink          Sink
+	config        *SinkConfig
+	msgCh         chan rig.ProcessOutputMessage
+	subscriptions []*ProcessOutputSubscription
+	doneCh        chan bool
+}
+
+func NewSinkRunner(config *SinkConfig) (*SinkRunner, error) {
+	sink, err := NewSink(config)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &SinkRunner{
+		sink:   sink,
+		config: config,
+		msgCh:  make(chan rig.ProcessOutputMessage, sinkBufferSize),
+		doneCh: make(chan bool),
+	}
+	go r.run()
+	return r, nil
+}
+
+func (r *SinkRunner) Attach(processes []*Process) {
+	for _, p := range processes {
+		if matchesDescriptors(r.config.Descriptors, p) {
+			r.subscriptions = append(r.subscriptions, p.outputDispatcher.SubscribeLossy(r.msgCh, nil))
+		}
+	}
+}
+
+func (r *SinkRunner) Close() {
+	for _, s := range r.subscriptions {
+		s.End()
+	}
+	close(r.doneCh)
+}
+
+func (r *SinkRunner) run() {
+	for {
+		select {
+		case msg := <-r.msgCh:
+			batch := []rig.ProcessOutputMessage{msg}
+		Batch:
+			for len(batch) < sinkBatchSize {
+				select {
+				case msg := <-r.msgCh:
+					batch = append(batch, msg)
+				default:
+					break Batch
+				}
+			}
+			if err := r.sink.Write(batch); err != nil {
+				log.Printf("[O] Error writing to %s sink: %v\n", r.config.Type, err)
+			}
+		case <-r.doneCh:
+			if err := r.sink.Close(); err != nil {
+				log.Printf("[O] Error closing %s sink: %v\n", r.config.Type, err)
+			}
+			return
+		}
+	}
+}
+
+// Descriptors are matched part by part, so "acme" matches every process of
+// the acme stack and "acme:api" every process of its api service.
+func matchesDescriptors(descriptors []string, p *Process) bool {
+	if len(descriptors) == 0 {
+		return true
+	}
+
+	fqd := []string{p.Service.Stack.Name, p.Service.Name, p.Name}
+	for _, descriptor := range descriptors {
+		parts := strings.SplitN(descriptor, ":", 3)
+		match := true
+		for i, part := range parts {
+			if part != fqd[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// === File sink, writing JSON lines
+
+type fileSink struct {
+	f *os.File
+}
+
+func newFileSink(path string) (*fileSink, error) {
+	f, err := os.OpenFile(utils.ExpandPath(path), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{f: f}, nil
+}
+
+func (s *fileSink) Write(msgs []rig.ProcessOutputMessage) error {
+	b, err := encodeJSONLines(msgs)
+	if err != nil {
+		return err
+	}
+	_, err = s.f.Write(b)
+	return err
+}
+
+func (s *fileSink) Close() error {
+	return s.f.Close()
+}
+
+// === TCP sink, writing JSON lines
+
+type tcpSink struct {
+	conn *reconnectingConn
+}
+
+func newTCPSink(address string) (*tcpSink, error) {
+	return &tcpSink{conn: &reconnectingConn{network: "tcp", address: address}}, nil
+}
+
+func (s *tcpSink) Write(msgs []rig.ProcessOutputMessage) error {
+	b, err := encodeJSONLines(msgs)
+	if err != nil {
+		return err
+	}
+	return s.conn.Write(b)
+}
+
+func (s *tcpSink) Close() error {
+	return s.conn.Close()
+}
+
+// === HTTP sink, posting batches of JSON lines
+
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPSink(url string) (*httpSink, error) {
+	if url == "" {
+		return nil, fmt.Errorf("HTTP sink needs a url")
+	}
+	return &httpSink{url: url, client: &http.Client{Timeout: sinkWriteTimeout}}, nil
+}
+
+func (s *httpSink) Write(msgs []rig.ProcessOutputMessage) error {
+	b, err := encodeJSONLines(msgs)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.url, "application/x-ndjson", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s responded with %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSink) Close() error {
+	return nil
+}
+
+// === Syslog sink, sending RFC 5424 messages
+
+type syslogSink struct {
+	conn     *reconnectingConn
+	hostname string
+	framed   bool
+}
+
+func newSyslogSink(network, address string) (*syslogSink, error) {
+	framed := false
+	switch network {
+	case "unix":
+		// Syslog daemons usually listen on datagram sockets
+		network = "unixgram"
+	case "udp":
+	case "tcp":
+		framed = true
+	default:
+		return nil, fmt.Errorf("Unknown syslog network '%s'", network)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+
+	return &syslogSink{
+		conn:     &reconnectingConn{network: network, address: address},
+		hostname: hostname,
+		framed:   framed,
+	}, nil
+}
+
+func (s *syslogSink) Write(msgs []rig.ProcessOutputMessage) error {
+	for _, msg := range msgs {
+		line := formatSyslog(msg, s.hostname)
+		if s.framed {
+			// Octet counting framing, RFC 6587
+			line = fmt.Sprintf("%d %s", len(line), line)
+		}
+		if err := s.conn.Write([]byte(line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *syslogSink) Close() error {
+	return s.conn.Close()
+}
+
+const (
+	syslogFacilityUser = 1
+	// Private enterprise number reserved for documentation, RFC 5612
+	syslogEnterpriseId = 32473
+)
+
+func formatSyslog(msg rig.ProcessOutputMessage, hostname string) string {
+	pri := syslogFacilityUser*8 + syslogSeverity(msg)
+	sd := fmt.Sprintf(`[rig@%d stack="%s" service="%s" process="%s"]`,
+		syslogEnterpriseId, escapeSDParam(msg.Stack), escapeSDParam(msg.Service), escapeSDParam(msg.Process))
+
+	return fmt.Sprintf("<%d>1 %s %s %s %s - %s %s",
+		pri, msg.Time.Format(time.RFC3339Nano), hostname, syslogName(msg.Service), syslogName(msg.Process), sd, msg.Content)
+}
+
+func syslogSeverity(msg rig.ProcessOutputMessage) int {
+	switch msg.Level {
+	case "fatal":
+		return 2
+	case "error":
+		return 3
+	case "warn":
+		return 4
+	case "info":
+		return 6
+	case "debug", "trace":
+		return 7
+	}
+	if msg.Stream == "stderr" {
+		return 3
+	}
+	return 6
+}
+
+// APP-NAME and PROCID are limited to 48 printable characters
+func syslogName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, name)
+	if len(name) > 48 {
+		name = name[:48]
+	}
+	if name == "" {
+		return "-"
+	}
+	return name
+}
+
+func escapeSDParam(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
+
+// === Helpers
+
+func encodeJSONLines(msgs []rig.ProcessOutputMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, msg := range msgs {
+		if err := enc.Encode(msg); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// A connection which is (re)established when writing, so a collector can be
+// restarted without restarting rigd.
+type reconnectingConn struct {
+	network string
+	address string
+	conn    net.Conn
+}
+
+func (c *reconnectingConn) Write(b []byte) error {
+	if c.conn == nil {
+		conn, err := net.DialTimeout(c.network, c.address, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		c.conn = conn
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(sinkWriteTimeout))
+	if _, err := c.conn.Write(b); err != nil {
+		c.conn.Close()
+		c.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (c *reconnectingConn) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
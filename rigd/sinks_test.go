@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"github.com/gocardless/rig"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_FormatSyslog(t *testing.T) {
+	at := time.Date(2016, 3, 1, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		msg      rig.ProcessOutputMessage
+		expected string
+	}{
+		{
+			rig.ProcessOutputMessage{Stack: "acme", Service: "api", Process: "web", Stream: "stdout", Content: "GET /", Time: at},
+			`<14>1 2016-03-01T12:30:00Z host api web - [rig@32473 stack="acme" service="api" process="web"] GET /`,
+		},
+		{
+			rig.ProcessOutputMessage{Stack: "acme", Service: "api", Process: "web", Stream: "stderr", Content: "boom", Time: at},
+			`<11>1 2016-03-01T12:30:00Z host api web - [rig@32473 stack="acme" service="api" process="web"] boom`,
+		},
+		{
+			rig.ProcessOutputMessage{Stack: "acme", Service: "api", Process: "web", Stream: "stderr", Level: "debug", Content: "sql", Time: at},
+			`<15>1 2016-03-01T12:30:00Z host api web - [rig@32473 stack="acme" service="api" process="web"] sql`,
+		},
+		{
+			rig.ProcessOutputMessage{Stack: `a"b`, Service: "my api", Process: "", Level: "warn", Content: "slow", Time: at},
+			`<12>1 2016-03-01T12:30:00Z host my_api - - [rig@32473 stack="a\"b" service="my api" process=""] slow`,
+		},
+	}
+
+	for _, test := range tests {
+		if line := formatSyslog(test.msg, "host"); line != test.expected {
+			t.Errorf("Expected %s, got %s", test.expected, line)
+		}
+	}
+}
+
+func Test_MatchesDescriptors(t *testing.T) {
+	svc := &Service{Name: "api", Stack: NewStack("acme")}
+	p := NewProcess("web", "rails server", svc)
+
+	tests := []struct {
+		descriptors []string
+		expected    bool
+	}{
+		{nil, true},
+		{[]string{"acme"}, true},
+		{[]string{"acme:api"}, true},
+		{[]string{"acme:api:web"}, true},
+		{[]string{"acme:api:worker"}, false},
+		{[]string{"acme:web"}, false},
+		{[]string{"other"}, false},
+		{[]string{"other", "acme:api"}, true},
+	}
+
+	for _, test := range tests {
+		if matchesDescriptors(test.descriptors, p) != test.expected {
+			t.Errorf("Expected %v matching acme:api:web to be %v", test.descriptors, test.expected)
+		}
+	}
+}
+
+func Test_SinkRunnerBatches(t *testing.T) {
+	arrived := make(chan bool)
+	release := make(chan bool)
+	batches := make(chan int, 10)
+	var first sync.Once
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lines := 0
+		for scanner := bufio.NewScanner(r.Body); scanner.Scan(); {
+			lines++
+		}
+		first.Do(func() {
+			arrived <- true
+			<-release
+		})
+		batches <- lines
+	}))
+	defer ts.Close()
+
+	svc := &Service{Name: "api", Stack: NewStack("acme")}
+	web := NewProcess("web", "rails server", svc)
+	worker := NewProcess("worker", "sidekiq", svc)
+
+	r, err := NewSinkRunner(&SinkConfig{Type: "http", URL: ts.URL, Descriptors: []string{"acme:api:web"}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer r.Close()
+	r.Attach([]*Process{web, worker})
+
+	web.outputDispatcher.Publish(rig.ProcessOutputMessage{Content: "first"})
+	select {
+	case <-arrived:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the first message to be sent")
+	}
+
+	// Published while the first batch is being sent, so sent together
+	for i := 0; i < 50; i++ {
+		web.outputDispatcher.Publish(rig.ProcessOutputMessage{Content: "next"})
+		worker.outputDispatcher.Publish(rig.ProcessOutputMessage{Content: "ignored"})
+	}
+	close(release)
+
+	for _, expected := range []int{1, 50} {
+		select {
+		case lines := <-batches:
+			if lines != expected {
+				t.Errorf("Expected a batch of %d, got %d", expected, lines)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected a batch of %d", expected)
+		}
+	}
+}
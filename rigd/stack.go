@@ -35,22 +35,35 @@ func (s *Stack) Stop() error {
 	return nil
 }
 
-func (s *Stack) SubscribeToOutput(c chan rig.ProcessOutputMessage, num int) {
+func (s *Stack) Restart() error {
+	var wg sync.WaitGroup
+	for _, svc := range s.Services {
+		wg.Add(1)
+		go func(svc *Service) {
+			svc.Restart()
+			wg.Done()
+		}(svc)
+	}
+	wg.Wait()
+	return nil
+}
+
+func (s *Stack) History(num int, filter *LogFilter) []*rig.ProcessOutputMessage {
 	var buffers []*ring.Ring
 	for _, svc := range s.Services {
 		for _, p := range svc.Processes {
-			buffers = append(buffers, p.buffer)
+			buffers = append(buffers, p.buffer.Ring())
 		}
 	}
+	return filter.Tail(buffers, num)
+}
 
-	tailBuffer := MultiTail(buffers, num)
-	for _, msg := range tailBuffer {
-		c <- *msg
-	}
-
+func (s *Stack) SubscribeToOutput(c chan rig.ProcessOutputMessage, num int, filter *LogFilter) ([]*rig.ProcessOutputMessage, []*ProcessOutputSubscription) {
+	var processes []*Process
 	for _, svc := range s.Services {
 		for _, p := range svc.Processes {
-			p.outputDispatcher.Subscribe(c)
+			processes = append(processes, p)
 		}
 	}
+	return subscribeToOutput(processes, c, num, filter)
 }
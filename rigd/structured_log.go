@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gocardless/rig"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	LogFormatAuto = "auto"
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+var (
+	levelKeys = []string{"level", "lvl", "severity"}
+	msgKeys   = []string{"msg", "message"}
+	timeKeys  = []string{"time", "ts", "timestamp", "@timestamp"}
+	errorKeys = []string{"error", "err"}
+
+	// Levels in increasing order of severity, with the aliases they're known by
+	levels = [][]string{
+		{"trace"},
+		{"debug"},
+		{"info", "notice"},
+		{"warn", "warning"},
+		{"error", "err"},
+		{"fatal", "critical", "panic"},
+	}
+)
+
+// parseStructuredLog fills in the structured fields of a message whose
+// content is a JSON object. With the auto format, the whole line has to be an
+// object. With the json format, anything before the first brace is ignored,
+// which allows for prefixes such as a timestamp.
+func parseStructuredLog(msg *rig.ProcessOutputMessage, format string) {
+	content := strings.TrimSpace(msg.Content)
+
+	switch format {
+	case LogFormatText:
+		return
+	case LogFormatJSON:
+		if i := strings.Index(content, "{"); i >= 0 {
+			content = content[i:]
+		}
+	}
+
+	if !strings.HasPrefix(content, "{") || !strings.HasSuffix(content, "}") {
+		return
+	}
+
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(content), &record); err != nil {
+		return
+	}
+
+	if v, ok := popField(record, levelKeys); ok {
+		msg.Level = normaliseLevel(v)
+	}
+	if v, ok := popField(record, msgKeys); ok {
+		msg.Msg = fieldString(v)
+	}
+	if v, ok := popField(record, errorKeys); ok {
+		msg.Error = fieldString(v)
+	}
+	if v, ok := popField(record, timeKeys); ok {
+		if t, ok := parseLogTime(v); ok {
+			msg.LogTime = &t
+		} else {
+			record["time"] = v
+		}
+	}
+
+	if len(record) > 0 {
+		msg.Fields = make(map[string]string)
+		for k, v := range record {
+			msg.Fields[k] = fieldString(v)
+		}
+	}
+}
+
+func popField(record map[string]interface{}, keys []string) (interface{}, bool) {
+	for _, k := range keys {
+		if v, exists := record[k]; exists {
+			delete(record, k)
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func fieldString(v interface{}) string {
+	if str, ok := v.(string); ok {
+		return str
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// Levels can be names, or numbers as used by bunyan and pino (10 for trace
+// up to 60 for fatal).
+func normaliseLevel(v interface{}) string {
+	if n, ok := v.(float64); ok {
+		i := int(n)/10 - 1
+		if i < 0 {
+			i = 0
+		} else if i >= len(levels) {
+			i = len(levels) - 1
+		}
+		return levels[i][0]
+	}
+
+	name := strings.ToLower(fieldString(v))
+	for _, aliases := range levels {
+		for _, alias := range aliases {
+			if name == alias {
+				return aliases[0]
+			}
+		}
+	}
+	return name
+}
+
+// isLevel returns whether level is the name of a level or an alias of one
+// isLogFormat tells whether format is one of the log formats, or empty for
+// the default
+func isLogFormat(format string) bool {
+	switch format {
+	case "", LogFormatAuto, LogFormatJSON, LogFormatText:
+		return true
+	}
+	return false
+}
+
+func isLevel(level string) bool {
+	name := normaliseLevel(level)
+	for _, aliases := range levels {
+		if aliases[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// levelRank orders levels by severity. Unknown levels rank as info.
+func levelRank(level string) int {
+	name := normaliseLevel(level)
+	for i, aliases := range levels {
+		if aliases[0] == name {
+			return i
+		}
+	}
+	return levelRank("info")
+}
+
+// Times can be RFC 3339 strings, or numbers of seconds (or milliseconds, as
+// used by pino) since the epoch
+func parseLogTime(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case string:
+		if parsed, err := time.Parse(time.RFC3339Nano, t); err == nil {
+			return parsed, true
+		}
+		if secs, err := strconv.ParseFloat(t, 64); err == nil {
+			return unixTime(secs), true
+		}
+	case float64:
+		return unixTime(t), true
+	}
+	return time.Time{}, false
+}
+
+func unixTime(secs float64) time.Time {
+	if secs > 1e12 {
+		secs /= 1000
+	}
+	whole, frac := math.Modf(secs)
+	return time.Unix(int64(whole), int64(frac*1e9))
+}
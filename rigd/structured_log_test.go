@@ -0,0 +1,95 @@
+package main
+
+import (
+	"github.com/gocardless/rig"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ParseStructuredLog(t *testing.T) {
+	received := time.Now()
+	msg := rig.ProcessOutputMessage{
+		Content: `{"level":"WARNING","msg":"slow query","time":"2014-05-01T12:00:00Z","duration":1.5,"table":"users"}`,
+		Time:    received,
+	}
+	parseStructuredLog(&msg, LogFormatAuto)
+
+	if msg.Level != "warn" {
+		t.Errorf("Expected level to be 'warn', got '%v'", msg.Level)
+	}
+	if msg.Msg != "slow query" {
+		t.Errorf("Expected msg to be 'slow query', got '%v'", msg.Msg)
+	}
+	if msg.LogTime == nil || !msg.LogTime.Equal(time.Date(2014, 5, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected time to be parsed, got %v", msg.LogTime)
+	}
+	if !msg.Time.Equal(received) {
+		t.Errorf("Expected the time the line was received to be kept, got %v", msg.Time)
+	}
+	if len(msg.Fields) != 2 || msg.Fields["duration"] != "1.5" || msg.Fields["table"] != "users" {
+		t.Errorf("Expected fields duration and table, got %v", msg.Fields)
+	}
+}
+
+func Test_ParseStructuredLogNumericLevel(t *testing.T) {
+	msg := rig.ProcessOutputMessage{Content: `{"level":50,"msg":"boom","err":"EOF"}`}
+	parseStructuredLog(&msg, LogFormatAuto)
+
+	if msg.Level != "error" {
+		t.Errorf("Expected level to be 'error', got '%v'", msg.Level)
+	}
+	if msg.Error != "EOF" {
+		t.Errorf("Expected error to be 'EOF', got '%v'", msg.Error)
+	}
+}
+
+func Test_ParseStructuredLogFormats(t *testing.T) {
+	prefixed := `12:00:00 {"level":"info","msg":"hello"}`
+
+	msg := rig.ProcessOutputMessage{Content: prefixed}
+	parseStructuredLog(&msg, LogFormatAuto)
+	if msg.Msg != "" {
+		t.Errorf("Expected a prefixed line not to be parsed in auto format")
+	}
+
+	msg = rig.ProcessOutputMessage{Content: prefixed}
+	parseStructuredLog(&msg, LogFormatJSON)
+	if msg.Msg != "hello" {
+		t.Errorf("Expected a prefixed line to be parsed in json format")
+	}
+
+	msg = rig.ProcessOutputMessage{Content: `{"level":"info","msg":"hello"}`}
+	parseStructuredLog(&msg, LogFormatText)
+	if msg.Msg != "" {
+		t.Errorf("Expected nothing to be parsed in text format")
+	}
+}
+
+func Test_LogFormatConfig(t *testing.T) {
+	svc := &Service{Name: "api", Stack: NewStack("acme"), Processes: map[string]*Process{}, Tasks: map[string]*Task{}}
+	svc.Processes["web"] = NewProcess("web", "rails server", svc)
+
+	for _, format := range []string{"", "auto", "json", "text"} {
+		svc.Processes["web"].Config = &ProcessConfig{LogFormat: format}
+		if err := svc.Configure(&Config{}, &ServiceConfig{}); err != nil {
+			t.Errorf("Expected '%s' to be a valid log_format, got %v", format, err)
+		}
+	}
+
+	svc.Processes["web"].Config = &ProcessConfig{LogFormat: "jsn"}
+	if err := svc.Configure(&Config{}, &ServiceConfig{}); err == nil || !strings.Contains(err.Error(), "log_format") {
+		t.Errorf("Expected an error for an unknown log_format, got %v", err)
+	}
+}
+
+func Test_LogFilterLevel(t *testing.T) {
+	f, _ := NewLogFilter(url.Values{"level": {"warn"}})
+
+	for level, match := range map[string]bool{"debug": false, "info": false, "warn": true, "error": true, "": false} {
+		if f.Match(rig.ProcessOutputMessage{Level: level}) != match {
+			t.Errorf("Expected match of level '%s' to be %v", level, match)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gocardless/rig"
+	"log"
+	"sync"
+	"time"
+)
+
+// Number of runs of a task or a scheduled process which are remembered
+const runHistoryLength = 20
+
+// A Task is a command of a service which is run to completion on demand,
+// such as a migration, rather than kept running like a process. It's run
+// like a process of the service, which isn't registered as one.
+type Task struct {
+	sync.Mutex
+	Name    string
+	Service *Service
+	process *Process
+	running bool
+}
+
+func NewTask(name, cmd string, service *Service) *Task {
+	return &Task{
+		Name:    name,
+		Service: service,
+		process: NewProcess(name, cmd, service),
+	}
+}
+
+// Run runs the task and waits for it to finish. It fails if the task is
+// already running.
+func (t *Task) Run() (*rig.ApiTaskRun, error) {
+	t.Lock()
+	if t.running {
+		t.Unlock()
+		return nil, fmt.Errorf("Task %s is already running", t.Sqd())
+	}
+	t.running = true
+	t.Unlock()
+
+	log.Printf("[S] Running task %s\n", t.Sqd())
+	run := t.process.runOnce()
+
+	t.Lock()
+	t.running = false
+	t.Unlock()
+	return run, nil
+}
+
+// Runs returns the most recent runs of the task, oldest first
+func (t *Task) Runs() []rig.ApiTaskRun {
+	return t.process.runs.Runs()
+}
+
+func (t *Task) Sqd() string {
+	return fmt.Sprintf("%s:%s", t.Service.Name, t.Name)
+}
+
+// runOnce runs the process to completion and records how it went
+func (p *Process) runOnce() *rig.ApiTaskRun {
+	p.stats.forgetExit()
+	run := &rig.ApiTaskRun{Task: p.Name, StartedAt: time.Now()}
+	err := p.run()
+	run.Duration = time.Since(run.StartedAt)
+
+	if code, exited := p.stats.ExitCode(); exited {
+		run.ExitCode = code
+	} else {
+		// It didn't even start
+		run.ExitCode = -1
+	}
+	if err != nil {
+		run.Error = err.Error()
+		log.Printf("[P] %v\n", err)
+	}
+
+	p.runs.Add(*run)
+	return run
+}
+
+// RunHistory keeps the most recent runs of a task or a scheduled process
+type RunHistory struct {
+	sync.Mutex
+	runs []rig.ApiTaskRun
+}
+
+func (h *RunHistory) Add(run rig.ApiTaskRun) {
+	h.Lock()
+	defer h.Unlock()
+	h.runs = append(h.runs, run)
+	if len(h.runs) > runHistoryLength {
+		h.runs = h.runs[len(h.runs)-runHistoryLength:]
+	}
+}
+
+func (h *RunHistory) Runs() []rig.ApiTaskRun {
+	h.Lock()
+	defer h.Unlock()
+	return append([]rig.ApiTaskRun{}, h.runs...)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_TaskRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "task-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stack := NewStack("acme")
+	svc := &Service{Name: "api", Dir: dir, Stack: stack, Config: &ServiceConfig{}, Processes: map[string]*Process{}}
+	task := NewTask("migrate", "exit 3", svc)
+
+	run, err := task.Run()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if run.ExitCode != 3 {
+		t.Errorf("Expected the exit code to be 3, got %d", run.ExitCode)
+	}
+
+	task.process.Cmd = "true"
+	if run, _ := task.Run(); run.ExitCode != 0 || run.Error != "" {
+		t.Errorf("Expected the second run to succeed, got %+v", run)
+	}
+
+	runs := task.Runs()
+	if len(runs) != 2 || runs[0].ExitCode != 3 || runs[1].ExitCode != 0 {
+		t.Errorf("Expected both runs in the history, got %+v", runs)
+	}
+}
+
+func Test_ServiceConfigureTasks(t *testing.T) {
+	svc := &Service{Name: "api", Processes: map[string]*Process{}, Tasks: map[string]*Task{}}
+	svc.Processes["web"] = NewProcess("web", "rails server", svc)
+
+	err := svc.Configure(&Config{}, &ServiceConfig{Tasks: map[string]string{"web": "true"}})
+	if err == nil {
+		t.Errorf("Expected an error for a task named like a process")
+	}
+
+	err = svc.Configure(&Config{}, &ServiceConfig{StartTasks: []string{"migrate"}})
+	if err == nil {
+		t.Errorf("Expected an error for an unknown start task")
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+// The web dashboard served on /ui. It's a single page talking to the same
+// API as the command line client, so it's kept free of dependencies and build
+// steps.
+const uiPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>rig</title>
+<style>
+  body { margin: 0; font: 13px/1.4 -apple-system, Helvetica, Arial, sans-serif; color: #222; display: flex; height: 100vh; }
+  #sidebar { width: 440px; overflow-y: auto; border-right: 1px solid #ddd; padding: 12px; box-sizing: border-box; }
+  #main { flex: 1; display: flex; flex-direction: column; min-width: 0; }
+  h1 { font-size: 18px; margin: 0 0 12px; }
+  .row { display: flex; align-items: center; padding: 3px 4px; border-radius: 3px; cursor: pointer; }
+  .row:hover { background: #f3f3f3; }
+  .row.selected { background: #e3eefc; }
+  .row .name { flex: 1; white-space: nowrap; overflow: hidden; text-overflow: ellipsis; }
+  .row .meta { color: #888; font-size: 11px; margin-right: 8px; white-space: nowrap; }
+  .depth-0 .name { font-weight: bold; }
+  .depth-1 { padding-left: 16px; }
+  .depth-2 { padding-left: 32px; }
+  .dot { display: inline-block; width: 8px; height: 8px; border-radius: 4px; margin-right: 6px; background: #ccc; }
+  .dot.running { background: #2a2; }
+  button { font-size: 11px; margin-left: 2px; padding: 1px 6px; }
+  #toolbar { padding: 8px 12px; border-bottom: 1px solid #ddd; display: flex; flex-wrap: wrap; gap: 6px; align-items: center; }
+  #toolbar input, #toolbar select { font-size: 12px; }
+  #logs, #config { flex: 1; overflow: auto; margin: 0; padding: 8px 12px; font: 12px/1.4 Menlo, Consolas, monospace; background: #1d1f21; color: #ddd; white-space: pre-wrap; }
+  #config { display: none; background: #fafafa; color: #222; }
+  .log .meta { color: #81a2be; }
+  .log .level-warn { color: #f0c674; }
+  .log .level-error, .log .level-fatal { color: #cc6666; font-weight: bold; }
+  #error { color: #c00; margin-left: auto; }
+</style>
+</head>
+<body>
+<div id="sidebar">
+  <h1>rig</h1>
+  <div id="tree"></div>
+</div>
+<div id="main">
+  <div id="toolbar">
+    <strong id="target">Select a stack, service or process</strong>
+    <input id="include" placeholder="include regexp">
+    <input id="exclude" placeholder="exclude regexp">
+    <input id="contains" placeholder="contains">
+    <select id="level">
+      <option value="">any level</option>
+      <option>debug</option><option>info</option><option>warn</option><option>error</option>
+    </select>
+    <button id="apply">Apply</button>
+    <button id="clear">Clear</button>
+    <button id="toggle-config">Config</button>
+    <span id="error"></span>
+  </div>
+  <pre id="logs"></pre>
+  <pre id="config"></pre>
+</div>
+<script>
+(function() {
+  var selected = null, stream = null, maxLines = 2000;
+
+  function $(id) { return document.getElementById(id); }
+
+  function path(d) {
+    return "/" + [d.Stack, d.Service, d.Process].filter(function(p) { return p; }).map(encodeURIComponent).join("/");
+  }
+
+  function name(d) {
+    return [d.Stack, d.Service, d.Process].filter(function(p) { return p; }).join(":");
+  }
+
+  function showError(err) { $("error").textContent = err ? String(err) : ""; }
+
+  function request(method, url) {
+    return fetch(url, { method: method }).then(function(resp) {
+      if (!resp.ok) { return resp.text().then(function(t) { throw new Error(t || resp.statusText); }); }
+      return resp;
+    });
+  }
+
+  function uptime(since) {
+    var s = Math.floor((Date.now() - new Date(since).getTime()) / 1000);
+    if (s < 60) { return s + "s"; }
+    if (s < 3600) { return Math.floor(s / 60) + "m"; }
+    if (s < 86400) { return Math.floor(s / 3600) + "h" + Math.floor(s % 3600 / 60) + "m"; }
+    return Math.floor(s / 86400) + "d" + Math.floor(s % 86400 / 3600) + "h";
+  }
+
+  function button(label, action, d) {
+    var b = document.createElement("button");
+    b.textContent = label;
+    b.onclick = function(e) {
+      e.stopPropagation();
+      request("POST", path(d) + "/" + action).then(function() { showError(); setTimeout(refresh, 500); }, showError);
+    };
+    return b;
+  }
+
+  function row(d, depth, proc) {
+    var el = document.createElement("div");
+    el.className = "row depth-" + depth + (selected && name(selected) === name(d) ? " selected" : "");
+    var label = document.createElement("span");
+    label.className = "name";
+    if (depth === 2) {
+      var dot = document.createElement("span");
+      dot.className = "dot" + (proc && proc.Status === 1 ? " running" : "");
+      label.appendChild(dot);
+    }
+    label.appendChild(document.createTextNode([d.Stack, d.Service, d.Process][depth]));
+    el.appendChild(label);
+    if (proc && proc.Status === 1) {
+      var meta = document.createElement("span");
+      meta.className = "meta";
+      meta.textContent = "pid " + proc.Pid + " · up " + uptime(proc.StartedAt) + (proc.Port ? " · :" + proc.Port : "");
+      el.appendChild(meta);
+    }
+    el.appendChild(button("start", "start", d));
+    el.appendChild(button("stop", "stop", d));
+    el.appendChild(button("restart", "restart", d));
+    el.onclick = function() { select(d); };
+    return el;
+  }
+
+  function refresh() {
+    Promise.all([
+      request("GET", "/list").then(function(r) { return r.json(); }),
+      request("GET", "/ps").then(function(r) { return r.json(); })
+    ]).then(function(res) {
+      var list = res[0], ps = res[1], tree = $("tree");
+      tree.innerHTML = "";
+      Object.keys(list).sort().forEach(function(stack) {
+        tree.appendChild(row({ Stack: stack }, 0));
+        Object.keys(list[stack]).sort().forEach(function(service) {
+          tree.appendChild(row({ Stack: stack, Service: service }, 1));
+          list[stack][service].sort().forEach(function(process) {
+            var proc = ((ps[stack] || {})[service] || []).filter(function(p) { return p.Name === process; })[0];
+            tree.appendChild(row({ Stack: stack, Service: service, Process: process }, 2, proc));
+          });
+        });
+      });
+    }, showError);
+  }
+
+  function select(d) {
+    selected = d;
+    $("target").textContent = name(d);
+    refresh();
+    tail();
+  }
+
+  function appendLog(m) {
+    var logs = $("logs");
+    var atBottom = logs.scrollTop + logs.clientHeight >= logs.scrollHeight - 4;
+    var line = document.createElement("div");
+    line.className = "log";
+    var meta = document.createElement("span");
+    meta.className = "meta";
+    meta.textContent = new Date(m.Time).toLocaleTimeString() + " " + name(m) + " | ";
+    line.appendChild(meta);
+    var content = document.createElement("span");
+    if (m.Level || m.Msg) {
+      content.className = "level-" + m.Level;
+      var fields = Object.keys(m.Fields || {}).sort().map(function(k) { return " " + k + "=" + m.Fields[k]; }).join("");
+      content.textContent = (m.Level || "").toUpperCase() + " " + (m.Msg || "") + fields + (m.Error ? " error=" + m.Error : "");
+    } else {
+      content.textContent = m.Content;
+    }
+    line.appendChild(content);
+    logs.appendChild(line);
+    while (logs.childNodes.length > maxLines) { logs.removeChild(logs.firstChild); }
+    if (atBottom) { logs.scrollTop = logs.scrollHeight; }
+  }
+
+  // Tails are streams of JSON messages, one per line
+  function tail() {
+    if (stream) { stream.abort(); }
+    if (!selected) { return; }
+    $("logs").innerHTML = "";
+
+    var params = new URLSearchParams({ num: "200", strip_ansi: "true" });
+    ["include", "exclude", "contains", "level"].forEach(function(p) {
+      if ($(p).value) { params.set(p, $(p).value); }
+    });
+
+    var controller = new AbortController();
+    stream = controller;
+    fetch(path(selected) + "/tail?" + params, { method: "POST", signal: controller.signal }).then(function(resp) {
+      if (!resp.ok) { return resp.text().then(function(t) { throw new Error(t); }); }
+      showError();
+      var reader = resp.body.getReader(), decoder = new TextDecoder(), buffer = "";
+      function read() {
+        return reader.read().then(function(res) {
+          if (res.done) { return; }
+          buffer += decoder.decode(res.value, { stream: true });
+          var lines = buffer.split("\n");
+          buffer = lines.pop();
+          lines.forEach(function(l) { if (l) { appendLog(JSON.parse(l)); } });
+          return read();
+        });
+      }
+      return read();
+    }).catch(function(err) { if (err.name !== "AbortError") { showError(err); } });
+  }
+
+  $("apply").onclick = tail;
+  $("clear").onclick = function() { $("logs").innerHTML = ""; };
+  $("toggle-config").onclick = function() {
+    var config = $("config"), logs = $("logs");
+    if (config.style.display === "block") {
+      config.style.display = "none";
+      logs.style.display = "block";
+      return;
+    }
+    request("GET", "/config").then(function(r) { return r.text(); }).then(function(text) {
+      config.textContent = text;
+      config.style.display = "block";
+      logs.style.display = "none";
+    }, showError);
+  };
+
+  refresh();
+  setInterval(refresh, 2000);
+})();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultWatchInterval = time.Second
+	defaultWatchDebounce = 500 * time.Millisecond
+)
+
+// Compiled watch settings of a process. Paths and Ignore are globs relative
+// to the service's dir, in which ** matches any number of directories. A glob
+// without a slash matches files (or directories, for Ignore) of that name at
+// any depth. Signal is sent on changes, or the process is restarted when it's
+// zero.
+type WatchRules struct {
+	Paths    []string
+	Ignore   []string
+	Interval time.Duration
+	Debounce time.Duration
+	Signal   syscall.Signal
+}
+
+func NewWatchRules(config *WatchConfig) (*WatchRules, error) {
+	if len(config.Paths) == 0 {
+		return nil, fmt.Errorf("watch needs paths")
+	}
+	rules := &WatchRules{
+		Paths:    config.Paths,
+		Ignore:   append([]string{".git"}, config.Ignore...),
+		Interval: defaultWatchInterval,
+		Debounce: defaultWatchDebounce,
+	}
+
+	for _, pattern := range append(append([]string{}, rules.Paths...), rules.Ignore...) {
+		if _, err := path.Match(strings.Replace(pattern, "**", "*", -1), ""); err != nil {
+			return nil, fmt.Errorf("invalid watch pattern '%s': %v", pattern, err)
+		}
+	}
+
+	if config.Interval != "" {
+		d, err := time.ParseDuration(config.Interval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid watch interval '%s'", config.Interval)
+		}
+		rules.Interval = d
+	}
+	if config.Debounce != "" {
+		d, err := time.ParseDuration(config.Debounce)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid watch debounce '%s'", config.Debounce)
+		}
+		rules.Debounce = d
+	}
+	if config.Signal != "" {
+		sig, err := ParseSignal(config.Signal)
+		if err != nil {
+			return nil, err
+		}
+		rules.Signal = sig
+	}
+
+	return rules, nil
+}
+
+// startWatch starts watching the files of the process, if it has watch
+// rules and isn't watched already.
+func (p *Process) startWatch() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.watch == nil || p.watcher != nil {
+		return
+	}
+	p.watcher = NewWatcher(p.Service.Dir, p.watch, p.fileChanged)
+	log.Printf("[P] Watching files of %s\n", p.Sqd())
+}
+
+// stopWatch returns whether the process was watched
+func (p *Process) stopWatch() bool {
+	p.mu.Lock()
+	w := p.watcher
+	p.watcher = nil
+	p.mu.Unlock()
+	if w == nil {
+		return false
+	}
+	w.Stop()
+	log.Printf("[P] Stopped watching files of %s\n", p.Sqd())
+	return true
+}
+
+func (p *Process) fileChanged(file string) {
+	if p.watch.Signal != 0 {
+		if p.status() != Running {
+			return
+		}
+		log.Printf("[P] %s changed, signalling %s\n", file, p.Sqd())
+		if err := p.Signal(p.watch.Signal, false); err != nil {
+			log.Printf("[P] %v\n", err)
+		}
+		return
+	}
+
+	log.Printf("[P] %s changed, restarting %s\n", file, p.Sqd())
+	if err := p.Restart(); err != nil {
+		log.Printf("[P] %v\n", err)
+	}
+}
+
+// carryOverWatches moves the watching of files over to the new instances of
+// processes after a reload.
+func carryOverWatches(oldStacks, stacks map[string]*Stack) {
+	for _, s := range oldStacks {
+		for _, svc := range s.Services {
+			for _, old := range svc.Processes {
+				if !old.stopWatch() {
+					continue
+				}
+				p, err := getProcess(stacks, old.descriptor())
+				if err == nil {
+					p.startWatch()
+				}
+			}
+		}
+	}
+}
+
+func (r *WatchRules) ignored(rel string) bool {
+	return matchesAny(r.Ignore, rel)
+}
+
+func (r *WatchRules) watched(rel string) bool {
+	return matchesAny(r.Paths, rel)
+}
+
+// mayContain returns whether files under the directory rel can be watched,
+// so that scanning can skip the others.
+func (r *WatchRules) mayContain(rel string) bool {
+	dir := strings.Split(rel, "/")
+	for _, pattern := range r.Paths {
+		if !strings.Contains(pattern, "/") || matchPrefix(strings.Split(pattern, "/"), dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+				return true
+			}
+		} else if matchGlob(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches a slash separated path against a glob in which **
+// matches any number of path segments.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// matchPrefix returns whether paths starting with the segments of dir can
+// match the pattern.
+func matchPrefix(pattern, dir []string) bool {
+	for ; len(dir) > 0; pattern, dir = pattern[1:], dir[1:] {
+		if len(pattern) == 0 {
+			return false
+		}
+		if pattern[0] == "**" {
+			return true
+		}
+		if ok, _ := path.Match(pattern[0], dir[0]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// A Watcher polls the files of a directory matching its rules, and calls
+// onChange with the first file which changed once no more changes have
+// happened for the debounce interval. onChange runs in its own goroutine,
+// and changes happening meanwhile are reported once it has returned.
+type Watcher struct {
+	dir      string
+	rules    *WatchRules
+	onChange func(file string)
+	stopCh   chan bool
+	doneCh   chan bool
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func NewWatcher(dir string, rules *WatchRules, onChange func(string)) *Watcher {
+	w := &Watcher{dir: dir, rules: rules, onChange: onChange, stopCh: make(chan bool), doneCh: make(chan bool)}
+	go w.run()
+	return w
+}
+
+func (w *Watcher) Stop() {
+	close(w.stopCh)
+}
+
+func (w *Watcher) run() {
+	ticker := time.NewTicker(w.rules.Interval)
+	defer ticker.Stop()
+
+	files := w.scan()
+	var changed string
+	var lastChange time.Time
+	busy := false
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.doneCh:
+			busy = false
+			continue
+		case <-w.stopCh:
+			return
+		}
+
+		current := w.scan()
+		if file := diffFiles(files, current); file != "" {
+			if changed == "" {
+				changed = file
+			}
+			lastChange = time.Now()
+		}
+		files = current
+
+		if changed != "" && !busy && time.Since(lastChange) >= w.rules.Debounce {
+			select {
+			case <-w.stopCh:
+				return
+			default:
+			}
+			busy = true
+			go func(file string) {
+				w.onChange(file)
+				select {
+				case w.doneCh <- true:
+				case <-w.stopCh:
+				}
+			}(changed)
+			changed = ""
+		}
+	}
+}
+
+func (w *Watcher) scan() map[string]fileState {
+	files := make(map[string]fileState)
+	filepath.Walk(w.dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Files can disappear while we're walking
+			return nil
+		}
+		rel, err := filepath.Rel(w.dir, p)
+		if err != nil || rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if info.IsDir() {
+			if w.rules.ignored(rel) || !w.rules.mayContain(rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if w.rules.ignored(rel) {
+			return nil
+		}
+		if !info.IsDir() && w.rules.watched(rel) {
+			files[rel] = fileState{modTime: info.ModTime(), size: info.Size()}
+		}
+		return nil
+	})
+	return files
+}
+
+// diffFiles returns a file which was created, modified or deleted, if any
+func diffFiles(before, after map[string]fileState) string {
+	for name, state := range after {
+		if old, exists := before[name]; !exists || old != state {
+			return name
+		}
+	}
+	for name := range before {
+		if _, exists := after[name]; !exists {
+			return name
+		}
+	}
+	return ""
+}
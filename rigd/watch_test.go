@@ -0,0 +1,219 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_MatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		expected      bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "lib/main.go", false},
+		{"lib/*.go", "lib/main.go", true},
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "lib/a/b/main.go", true},
+		{"lib/**", "lib/a/b/main.go", true},
+		{"lib/**", "app/main.go", false},
+		{"lib/**/test/*.rb", "lib/test/a.rb", true},
+		{"lib/**/test/*.rb", "lib/a/b/test/a.rb", true},
+		{"lib/**/test/*.rb", "lib/a/b/a.rb", false},
+	}
+
+	for _, test := range tests {
+		if matchGlob(test.pattern, test.name) != test.expected {
+			t.Errorf("Expected %s matching %s to be %v", test.pattern, test.name, test.expected)
+		}
+	}
+}
+
+func Test_WatchRules(t *testing.T) {
+	rules, err := NewWatchRules(&WatchConfig{
+		Paths:  []string{"*.rb", "config/*.yml"},
+		Ignore: []string{"tmp", "vendor/**"},
+		Signal: "sighup",
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if rules.Signal != syscall.SIGHUP {
+		t.Errorf("Expected SIGHUP, got %v", rules.Signal)
+	}
+	if rules.Debounce != defaultWatchDebounce || rules.Interval != defaultWatchInterval {
+		t.Errorf("Expected the default debounce and interval")
+	}
+
+	if !rules.watched("app/models/user.rb") || !rules.watched("config/app.yml") {
+		t.Errorf("Expected files matching the paths to be watched")
+	}
+	if rules.watched("config/locales/en.yml") {
+		t.Errorf("Expected config/locales/en.yml not to be watched")
+	}
+	for _, name := range []string{".git", "tmp", "app/tmp", "vendor/gems/a.rb"} {
+		if !rules.ignored(name) {
+			t.Errorf("Expected %s to be ignored", name)
+		}
+	}
+
+	invalid := []*WatchConfig{
+		{},
+		{Paths: []string{"[a"}},
+		{Paths: []string{"*"}, Debounce: "soon"},
+		{Paths: []string{"*"}, Interval: "0s"},
+		{Paths: []string{"*"}, Signal: "NOPE"},
+	}
+	for _, config := range invalid {
+		if _, err := NewWatchRules(config); err == nil {
+			t.Errorf("Expected an error for %+v", config)
+		}
+	}
+}
+
+func Test_WatchRulesMayContain(t *testing.T) {
+	tests := []struct {
+		paths    []string
+		dir      string
+		expected bool
+	}{
+		{[]string{"*.rb"}, "app/models", true},
+		{[]string{"config/*.yml"}, "config", true},
+		{[]string{"config/*.yml"}, "config/locales", false},
+		{[]string{"config/*.yml"}, "node_modules", false},
+		{[]string{"lib/**/*.go"}, "lib/a/b", true},
+		{[]string{"lib/**/*.go"}, "app", false},
+		{[]string{"*/views/*.erb"}, "app/views", true},
+		{[]string{"*/views/*.erb"}, "app/models", false},
+		{[]string{"config/*.yml", "app/**"}, "app/models", true},
+	}
+
+	for _, test := range tests {
+		rules := &WatchRules{Paths: test.paths}
+		if rules.mayContain(test.dir) != test.expected {
+			t.Errorf("Expected %v containing %s to be %v", test.paths, test.dir, test.expected)
+		}
+	}
+}
+
+func Test_WatcherDoesntWaitForChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rig-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rules, _ := NewWatchRules(&WatchConfig{Paths: []string{"*"}, Interval: "10ms", Debounce: "0s"})
+	changes := make(chan string, 10)
+	block := make(chan bool)
+	w := NewWatcher(dir, rules, func(file string) {
+		changes <- file
+		<-block
+	})
+	time.Sleep(30 * time.Millisecond)
+
+	// Files are moved in, so a scan can't see them empty and then written to
+	other, err := ioutil.TempDir("", "rig-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(other)
+	create := func(name string) {
+		ioutil.WriteFile(filepath.Join(other, name), []byte("1"), 0644)
+		os.Rename(filepath.Join(other, name), filepath.Join(dir, name))
+	}
+
+	create("a")
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a change")
+	}
+
+	// Changes during a slow onChange are reported once it returns
+	create("b")
+	time.Sleep(50 * time.Millisecond)
+	if len(changes) != 0 {
+		t.Errorf("Expected no change while onChange is running")
+	}
+	close(block)
+	select {
+	case file := <-changes:
+		if file != "b" {
+			t.Errorf("Expected b to trigger the change, got %s", file)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected the pending change once onChange returned")
+	}
+
+	stopped := make(chan bool)
+	go func() {
+		w.Stop()
+		stopped <- true
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Errorf("Expected stopping not to block")
+	}
+}
+
+func Test_Watcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rig-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "lib"), 0755)
+	os.MkdirAll(filepath.Join(dir, "log"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "lib", "app.rb"), []byte("1"), 0644)
+
+	rules, _ := NewWatchRules(&WatchConfig{
+		Paths:    []string{"**"},
+		Ignore:   []string{"log"},
+		Interval: "10ms",
+		Debounce: "50ms",
+	})
+	changes := make(chan string, 10)
+	w := NewWatcher(dir, rules, func(file string) { changes <- file })
+	defer w.Stop()
+	time.Sleep(30 * time.Millisecond)
+
+	ioutil.WriteFile(filepath.Join(dir, "log", "dev.log"), []byte("ignored"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "lib", "app.rb"), []byte("22"), 0644)
+	for i := 0; i < 3; i++ {
+		time.Sleep(15 * time.Millisecond)
+		ioutil.WriteFile(filepath.Join(dir, "lib", "new.rb"), []byte{byte(i)}, 0644)
+	}
+
+	select {
+	case file := <-changes:
+		if file != "lib/app.rb" {
+			t.Errorf("Expected lib/app.rb to trigger the change, got %s", file)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a change")
+	}
+
+	// The burst of changes is debounced into one
+	select {
+	case file := <-changes:
+		t.Errorf("Expected a single change, got another for %s", file)
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	os.Remove(filepath.Join(dir, "lib", "new.rb"))
+	select {
+	case file := <-changes:
+		if file != "lib/new.rb" {
+			t.Errorf("Expected lib/new.rb to trigger the change, got %s", file)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected deleting a file to be a change")
+	}
+}
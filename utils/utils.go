@@ -3,10 +3,13 @@ package utils
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
 	"io"
 	"os/user"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // https://github.com/dotcloud/docker/blob/940d58806c3e3d4409a7eee4859335e98139d09f/image.go#L218-225
@@ -46,3 +49,33 @@ func ExpandPath(path string) string {
 
 	return path
 }
+
+// ParseDuration is like time.ParseDuration, but also accepts a number of
+// days such as "2d".
+func ParseDuration(str string) (time.Duration, error) {
+	if strings.HasSuffix(str, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(str, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(str)
+}
+
+// ParseBytes parses a number of bytes with an optional K, M, G or T suffix,
+// in powers of 1024, such as "512M".
+func ParseBytes(str string) (uint64, error) {
+	multiplier := uint64(1)
+	upper := strings.TrimSuffix(strings.ToUpper(str), "B")
+	if i := strings.IndexAny(upper, "KMGT"); i >= 0 && i == len(upper)-1 {
+		multiplier = 1 << (10 * uint(strings.Index("KMGT", upper[i:])+1))
+		upper = upper[:i]
+	}
+
+	n, err := strconv.ParseUint(upper, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%s'", str)
+	}
+	return n * multiplier, nil
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"os/user"
 	"testing"
+	"time"
 )
 
 type expandPathTest struct {
@@ -31,3 +32,48 @@ func TestExpandPath(t *testing.T) {
 		}
 	}
 }
+
+func TestParseDuration(t *testing.T) {
+	parseDurationTests := map[string]time.Duration{
+		"1d":  24 * time.Hour,
+		"3h":  3 * time.Hour,
+		"90m": 90 * time.Minute,
+	}
+
+	for str, expected := range parseDurationTests {
+		d, err := ParseDuration(str)
+		if err != nil {
+			t.Errorf("%s : unexpected error %v", str, err)
+		} else if d != expected {
+			t.Errorf("%s : %v should equal %v", str, d, expected)
+		}
+	}
+
+	if _, err := ParseDuration("xd"); err == nil {
+		t.Errorf("xd : expected an error")
+	}
+}
+
+func TestParseBytes(t *testing.T) {
+	parseBytesTests := map[string]uint64{
+		"100":  100,
+		"4k":   4096,
+		"512M": 512 << 20,
+		"2GB":  2 << 30,
+	}
+
+	for str, expected := range parseBytesTests {
+		n, err := ParseBytes(str)
+		if err != nil {
+			t.Errorf("%s : unexpected error %v", str, err)
+		} else if n != expected {
+			t.Errorf("%s : %d should equal %d", str, n, expected)
+		}
+	}
+
+	for _, str := range []string{"", "G", "1.5G", "12X"} {
+		if _, err := ParseBytes(str); err == nil {
+			t.Errorf("%s : expected an error", str)
+		}
+	}
+}